@@ -7,13 +7,17 @@ import (
 
 // IgnoreCache will force re-parsing of the configuration file
 //
-func (config Configuration ) IgnoreCache( flag bool ) Configuration {
+func (config *Configuration ) IgnoreCache( flag bool ) *Configuration {
+	config.mu.Lock()
+	defer config.mu.Unlock()
 	config.ignoreCache = flag
 	return config
 }
 // SetCache Set the cache filename. This will notalter the data.
 // To change the data, call LoadCache()
-func (config Configuration) SetCache(cache string) Configuration {
+func (config *Configuration) SetCache(cache string) *Configuration {
+	config.mu.Lock()
+	defer config.mu.Unlock()
 	config.cacheFile = cache
 	config.IsCache = false
 	return config
@@ -24,6 +28,8 @@ func (config Configuration) SetCache(cache string) Configuration {
 // of the configuration out.
 //
 func (config *Configuration) SaveCache() error {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
 	if config.cacheFile != "" && config.IsLoaded {
 		cache, err := os.Create(config.cacheFile)
 		if err != nil {
@@ -41,7 +47,10 @@ func (config *Configuration) SaveCache() error {
 func (config *Configuration) LoadCache() (*Configuration, error) {
 	var newC Configuration
 
-	cache, err := os.Open(config.cacheFile)
+	config.mu.RLock()
+	cacheFile := config.cacheFile
+	config.mu.RUnlock()
+	cache, err := os.Open(cacheFile)
 	if err != nil {
 		return config, err
 	}
@@ -51,7 +60,12 @@ func (config *Configuration) LoadCache() (*Configuration, error) {
 	if err != nil {
 		return config, err
 	}
+	config.mu.Lock()
+	defer config.mu.Unlock()
 	config.ConfigMap = newC.ConfigMap
+	config.Inheritance = newC.Inheritance
+	config.SectionOrder = newC.SectionOrder
+	config.OptionOrder = newC.OptionOrder
 	config.IsLoaded = true
 	config.IsCache = true
 	return config, nil
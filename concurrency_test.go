@@ -0,0 +1,96 @@
+package gofig
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// These tests are meant to be run with 'go test -race'
+
+const testdata_concurrent = `
+[db]
+host = localhost
+port = 5432
+url = postgres://${host}:${port}
+
+[testdb : db]
+host = testhost
+`
+
+func TestConcurrentReadWrite(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_concurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				config.SetString("db", "port", strconv.Itoa(i*1000+j))
+				config.SetString("new"+strconv.Itoa(i), "key", "value")
+				config.DeleteOption("new"+strconv.Itoa(i), "key")
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := config.GetString("testdb", "url"); err != nil {
+					t.Error(err)
+					return
+				}
+				config.GetSectionNames()
+				config.GetMergedSection("testdb")
+				if section, found := config.GetSection("db"); found {
+					for option := range section {
+						section[option] = "copy"
+					}
+				}
+				config.Explain("testdb", "port")
+				config.WriteTo(io.Discard)
+				_ = config.String()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConcurrentDefaults(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_concurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				config.SetAddOnDefault(j%2 == 0)
+				option := "opt" + strconv.Itoa(j)
+				if value := config.GetStringWithDefault("db", option, "x"); value != "x" {
+					t.Errorf("Default for %s was %q rather than x", option, value)
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				config.GetIntWithDefault("testdb", "timeout", 30)
+				config.IsOption("db", "opt"+strconv.Itoa(j))
+				config.OptionNames("_default")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !config.IsOption("_default", "db") {
+		t.Errorf("Defaulted options should be recorded in _default")
+	}
+}
@@ -0,0 +1,567 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A struct can be filled in from a configuration with Unmarshal or Decode.
+// Each field is an option, named by an 'ini' tag or, without one, by the
+// field name (matched ignoring case):
+//
+//   type Settings struct {
+//       Name    string                         // option 'name' in [default]
+//       DB      Database       `ini:"db"`      // the [db] section
+//       Ignored string         `ini:"-"`
+//   }
+//   type Database struct {
+//       Host    string         `ini:"host"`
+//       Port    int            `ini:"port"`
+//       Timeout time.Duration  `ini:"timeout"` // 30s, 1m, ...
+//       Hosts   []string       `ini:"hosts"`   // a, b, c
+//       Limits  map[string]int `ini:"limits"`  // read = 10, write = 5
+//       Pool    *Pool          `ini:"db.pool"` // the [db.pool] section
+//   }
+//
+// Struct fields (other than those with an UnmarshalText method) are
+// sections, so they can be nested. Embedded structs share the section of
+// the struct they are in. Slices are comma separated lists and maps are
+// comma separated 'key = value' pairs; a map that isn't an option is
+// filled in with all of the options of the section with its name.
+// Pointers are only set when there is a value for them. Fields without a
+// value in the configuration are left as they are, unless they have a
+// default tag:
+//
+//   Port    int           `ini:"port" default:"5432"`
+//   Timeout time.Duration `ini:"timeout" default:"30s"`
+//
+// Defaults are used just as GetStringWithDefault uses them: the option is
+// recorded in the '_default' section (see DefaultedOptions) and, with
+// SetAddOnDefault, added to the section.
+//
+// Values can be checked with a validate tag, using the rules of a Schema
+// without the type, which comes from the field:
+//
+//   Port  int      `ini:"port" validate:"required,min=1,max=65535"`
+//   Mode  string   `ini:"mode" validate:"oneof=ro rw"`
+//   Hosts []string `ini:"hosts" validate:"min=1,pattern=^[a-z.]+$"`
+//
+// For slices, min and max are the number of items and the other rules are
+// checked for each item. A required section must exist. Every value that
+// breaks a rule is returned in ValidationErrors.
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+// ErrInvalidTarget is returned when Unmarshal or Decode isn't given a
+// pointer to a struct
+var ErrInvalidTarget = errors.New("Target must be a non-nil pointer to a struct")
+
+// Unmarshal will fill in the struct that v points to. Its fields are
+// options in the 'default' section and its struct fields are sections.
+// All of the values that couldn't be converted are returned, joined
+// together, as *ConversionError.
+func Unmarshal(config *Configuration, v any) error {
+	config.mu.Lock() // Defaults may be recorded
+	defer config.mu.Unlock()
+	return config.decode("default", v, true)
+}
+
+// Decode will fill in the struct that v points to from a single section.
+// v may also point to a map, which is filled in with every option in the
+// section. If the section doesn't exist an error wrapping
+// ErrSectionNotFound is returned.
+func (config *Configuration) Decode(sectionName string, v any) error {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	return config.decodeSectionNamed(sectionName, v, true)
+}
+
+// Unmarshal will fill in a struct from the snapshot, see Unmarshal. The
+// defaults used are not recorded, as a snapshot can't be changed.
+func (snap *Snapshot) Unmarshal(v any) error {
+	snap.config.mu.RLock()
+	defer snap.config.mu.RUnlock()
+	return snap.config.decode("default", v, false)
+}
+
+// Decode will fill in a struct from a section of the snapshot, see
+// Configuration.Decode. The defaults used are not recorded.
+func (snap *Snapshot) Decode(sectionName string, v any) error {
+	snap.config.mu.RLock()
+	defer snap.config.mu.RUnlock()
+	return snap.config.decodeSectionNamed(sectionName, v, false)
+}
+
+// decodeSectionNamed does the work of Decode. The caller must hold a
+// write lock if record is true, and a read lock otherwise.
+func (config *Configuration) decodeSectionNamed(sectionName string, v any, record bool) error {
+	sectionName = conformSectionName(sectionName)
+	if !config.isSection(sectionName) {
+		return fmt.Errorf("%w: '%s'", ErrSectionNotFound, sectionName)
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && !rv.IsNil() && rv.Elem().Kind() == reflect.Map {
+		d := &decoder{config: config, record: record}
+		d.decodeSection(sectionName, rv.Elem(), rv.Elem().Type().String())
+		return d.err()
+	}
+	return config.decode(sectionName, v, record)
+}
+
+// decode checks v and fills it in from the section. If record is true the
+// defaults used are recorded, and the caller must hold a write lock.
+// Otherwise a read lock is enough.
+func (config *Configuration) decode(sectionName string, v any, record bool) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidTarget
+	}
+	d := &decoder{config: config, record: record}
+	d.decodeNested(sectionName, rv.Elem(), rv.Elem().Type().Name())
+	return d.err()
+}
+
+// decoder collects the errors found while filling in a struct
+type decoder struct {
+	config     *Configuration
+	record     bool // Record the defaults used in '_default'
+	errs       []error
+	violations ValidationErrors
+	active     map[nested]bool // The structs being filled in
+}
+
+// nested is a struct type being filled in from a section
+type nested struct {
+	t       reflect.Type
+	section string
+}
+
+// err returns all of the errors found, with the violations of validate
+// tags together as ValidationErrors
+func (d *decoder) err() error {
+	switch {
+	case len(d.errs) == 0 && len(d.violations) == 0:
+		return nil
+	case len(d.errs) == 0:
+		return d.violations
+	case len(d.violations) > 0:
+		return errors.Join(append(d.errs, d.violations)...)
+	}
+	return errors.Join(d.errs...)
+}
+
+// field describes how a struct field maps onto the configuration
+type field struct {
+	name      string   // option or section name
+	tagged    bool     // the name came from a tag, so must match exactly
+	options   []string // anything after the name in the tag: omitempty, ...
+	skip      bool
+	flatten   bool // an embedded struct, in the same section
+	isSection bool
+}
+
+// fieldInfo reads the 'ini' tag of a struct field
+func fieldInfo(sf reflect.StructField) field {
+	f := field{name: sf.Name}
+	tag, tagged := sf.Tag.Lookup("ini")
+	if tag == "-" {
+		f.skip = true
+		return f
+	}
+	parts := strings.Split(tag, ",")
+	if tagged && strings.TrimSpace(parts[0]) != "" {
+		f.name, f.tagged = strings.TrimSpace(parts[0]), true
+	}
+	f.options = parts[1:]
+	f.isSection = isSectionType(sf.Type)
+	f.flatten = sf.Anonymous && !f.tagged && f.isSection
+	f.skip = !sf.IsExported() && !f.flatten
+	return f
+}
+
+// isSectionType returns true if a field of this type is a section
+func isSectionType(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// find returns the name in the list matching the field: exactly if it was
+// tagged, otherwise ignoring case.
+func (f field) find(names []string) (string, bool) {
+	for _, name := range names {
+		if name == f.name {
+			return name, true
+		}
+	}
+	if !f.tagged {
+		for _, name := range names {
+			if strings.EqualFold(name, f.name) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// mergedNames returns the names of the options in a section, including
+// those it inherits
+func (config *Configuration) mergedNames(sectionName string) []string {
+	options := make(ConfigOption)
+	config.mergeInto(options, sectionName, make(map[string]bool))
+	return orderedNames(nil, options)
+}
+
+// decodeStruct fills in the fields of a struct from a section
+func (d *decoder) decodeStruct(sectionName string, rv reflect.Value, path string) {
+	options := d.config.mergedNames(sectionName)
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		f := fieldInfo(sf)
+		if f.skip {
+			continue
+		}
+		fv := rv.Field(i)
+		fieldPath := sf.Name
+		if path != "" {
+			fieldPath = path + "." + sf.Name
+		}
+
+		if f.flatten {
+			if fv.Kind() == reflect.Pointer && fv.IsNil() && !fv.CanSet() {
+				d.errs = append(d.errs, fmt.Errorf("Field %s: Cannot set an embedded pointer to an unexported struct", fieldPath))
+				continue
+			}
+			d.decodeNested(sectionName, fv, fieldPath)
+			continue
+		}
+		rule := d.rule(sf, fieldPath)
+
+		if f.isSection {
+			if section, found := f.find(d.config.sectionNames()); found {
+				d.decodeNested(section, fv, fieldPath)
+				continue
+			}
+			if rule != nil && rule.Required {
+				d.violate(&ValidationError{Section: f.name, Field: fieldPath, Err: ErrRequired, Source: d.config.source()})
+			}
+			if fv.Kind() == reflect.Struct {
+				d.decodeNested(f.name, fv, fieldPath) // For any defaults
+			}
+			continue
+		}
+		option, found := f.find(options)
+		if !found || (fv.Kind() == reflect.Map && f.hasOption("section")) {
+			if section, found := f.find(d.config.sectionNames()); found && fv.Kind() == reflect.Map {
+				d.decodeSection(section, fv, fieldPath)
+			} else if defaultValue, hasDefault := sf.Tag.Lookup("default"); hasDefault {
+				if d.useDefault(sectionName, f.name, defaultValue, fv, fieldPath) {
+					d.validate(rule, sectionName, f.name, conformValue(defaultValue), fv.Type(), fieldPath, false)
+				}
+			} else if rule != nil && rule.Required {
+				d.violate(&ValidationError{Section: sectionName, Option: f.name, Field: fieldPath,
+					Err: ErrRequired, Source: d.config.source()})
+			}
+			continue
+		}
+		if value, ok := d.decodeOption(sectionName, option, fv, fieldPath); ok {
+			d.validate(rule, sectionName, option, value, fv.Type(), fieldPath, true)
+		}
+	}
+}
+
+// decodeNested fills in a struct field, allocating it if it is a nil
+// pointer. A struct that refers to itself, such as a tree, is only filled
+// in once from each section, rather than forever.
+func (d *decoder) decodeNested(sectionName string, fv reflect.Value, path string) {
+	t := fv.Type()
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	key := nested{t: t, section: sectionName}
+	if d.active[key] {
+		return
+	}
+	if d.active == nil {
+		d.active = make(map[nested]bool)
+	}
+	d.active[key] = true
+	d.decodeStruct(sectionName, allocate(fv), path)
+	delete(d.active, key)
+}
+
+// rule reads the validate tag of a field, returning nil if it has none
+func (d *decoder) rule(sf reflect.StructField, path string) *Rule {
+	tag, found := sf.Tag.Lookup("validate")
+	if !found {
+		return nil
+	}
+	t := sf.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		t = t.Elem() // The rules are for each item
+	}
+	rule := &Rule{Type: ruleType(t)}
+	if err := parseRule(tag, rule); err != nil {
+		d.errs = append(d.errs, fmt.Errorf("Field %s: %w", path, err))
+		return nil
+	}
+	return rule
+}
+
+// ruleType returns the type of rule that suits values of a Go type
+func ruleType(t reflect.Type) Type {
+	if t == durationType {
+		return TypeDuration
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return TypeString
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return TypeInt
+	case reflect.Float32, reflect.Float64:
+		return TypeFloat
+	case reflect.Bool:
+		return TypeBool
+	}
+	return TypeString
+}
+
+// validate checks a value that was stored in a field against its rule.
+// Values from the configuration are given the place they were set.
+func (d *decoder) validate(rule *Rule, sectionName, option, value string, t reflect.Type, path string, fromConfig bool) {
+	if rule == nil {
+		return
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var failed []error
+	switch {
+	case t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8:
+		items := splitList(value)
+		failed = rule.checkCount(len(items))
+		each := *rule
+		each.Min, each.Max = nil, nil
+		for _, item := range items {
+			failed = append(failed, each.check(item)...)
+		}
+	case t.Kind() == reflect.Map:
+		failed = rule.checkCount(len(splitList(value)))
+	default:
+		failed = rule.check(value)
+	}
+
+	var origin Origin
+	if fromConfig {
+		origin = d.config.explain(sectionName, option)[0]
+	}
+	for _, err := range failed {
+		d.violate(&ValidationError{Section: sectionName, Option: option, Value: value, Field: path,
+			Source: origin.Source, Line: origin.Line, Err: err})
+	}
+}
+
+// violate records a value that breaks a validate rule
+func (d *decoder) violate(violation *ValidationError) {
+	d.violations = append(d.violations, violation)
+}
+
+// useDefault sets a field from its default tag, recording it if needed.
+// The tag is read the way SetString reads a value, so the field gets the
+// same value as the copy SetAddOnDefault puts in the section.
+func (d *decoder) useDefault(sectionName, option, defaultValue string, fv reflect.Value, path string) bool {
+	if !d.set(fv, conformValue(defaultValue), sectionName, option, path) {
+		return false
+	}
+	if d.record {
+		d.config.useDefault(sectionName, option, defaultValue)
+	}
+	return true
+}
+
+// decodeSection fills in a map with all of the options of a section
+func (d *decoder) decodeSection(sectionName string, fv reflect.Value, path string) {
+	if fv.IsNil() {
+		fv.Set(reflect.MakeMap(fv.Type()))
+	}
+	for _, option := range d.config.mergedNames(sectionName) {
+		value, ok := d.value(sectionName, option, path)
+		if !ok {
+			continue
+		}
+		key, elem := reflect.New(fv.Type().Key()).Elem(), reflect.New(fv.Type().Elem()).Elem()
+		if !d.set(key, option, sectionName, option, path) || !d.set(elem, value, sectionName, option, path) {
+			continue
+		}
+		fv.SetMapIndex(key, elem)
+	}
+}
+
+// decodeOption sets a field from a single option, returning the value
+// if it was set
+func (d *decoder) decodeOption(sectionName, option string, fv reflect.Value, path string) (string, bool) {
+	value, ok := d.value(sectionName, option, path)
+	if !ok || !d.set(fv, value, sectionName, option, path) {
+		return "", false
+	}
+	return value, true
+}
+
+// value returns an option with its references replaced
+func (d *decoder) value(sectionName, option, path string) (string, bool) {
+	raw, _ := d.config.findOption(sectionName, option, nil)
+	value, err := d.config.expand(sectionName, option, raw, nil)
+	if err != nil {
+		d.errs = append(d.errs, fmt.Errorf("Field %s: %w", path, err))
+		return "", false
+	}
+	return value, true
+}
+
+// set converts a value and stores it in the field, recording any error
+func (d *decoder) set(fv reflect.Value, value, sectionName, option, path string) bool {
+	if err := setValue(fv, value); err != nil {
+		d.errs = append(d.errs, &ConversionError{Section: sectionName, Option: option, Value: value,
+			Type: fv.Type().String(), Field: path, Err: err})
+		return false
+	}
+	return true
+}
+
+// allocate returns the struct a field holds, making it if it is a nil pointer
+func allocate(fv reflect.Value) reflect.Value {
+	if fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return fv.Elem()
+	}
+	return fv
+}
+
+// setValue converts the value to the type of fv and stores it
+func setValue(fv reflect.Value, value string) error {
+	if fv.Kind() == reflect.Pointer {
+		elem := reflect.New(fv.Type().Elem())
+		if err := setValue(elem.Elem(), value); err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	}
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+	if fv.Type() == durationType {
+		duration, err := time.ParseDuration(value)
+		if err == nil {
+			fv.SetInt(int64(duration))
+		}
+		return err
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 0, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(value, 0, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		c, err := strconv.ParseComplex(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetComplex(c)
+	case reflect.Interface:
+		if fv.NumMethod() != 0 {
+			return fmt.Errorf("Unsupported type %s", fv.Type())
+		}
+		fv.Set(reflect.ValueOf(value))
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			fv.SetBytes([]byte(value))
+			return nil
+		}
+		items := splitList(value)
+		slice := reflect.MakeSlice(fv.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), item); err != nil {
+				return fmt.Errorf("item %d: %w", i+1, err)
+			}
+		}
+		fv.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(fv.Type())
+		for _, item := range splitList(value) {
+			k, v, found := strings.Cut(item, "=")
+			if !found {
+				return fmt.Errorf("'%s' is not a 'key = value' pair", item)
+			}
+			key, elem := reflect.New(fv.Type().Key()).Elem(), reflect.New(fv.Type().Elem()).Elem()
+			if err := setValue(key, strings.TrimSpace(k)); err != nil {
+				return fmt.Errorf("key '%s': %w", k, err)
+			}
+			if err := setValue(elem, strings.TrimSpace(v)); err != nil {
+				return fmt.Errorf("value of '%s': %w", k, err)
+			}
+			m.SetMapIndex(key, elem)
+		}
+		fv.Set(m)
+	default:
+		return fmt.Errorf("Unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, trimming the spaces from each
+// item. An empty value is an empty list.
+func splitList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return []string{}
+	}
+	items := strings.Split(value, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
@@ -0,0 +1,371 @@
+package gofig
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testdata_decode = `
+name = service
+debug = yes
+ratio = 0.5
+
+[db]
+host = localhost
+port = 5432
+timeout = 1m30s
+hosts = a, b , c
+limits = read = 10, write = 5
+addr = 127.0.0.1
+url = postgres://${host}:${port}
+
+[db.pool]
+size = 20
+
+[replica : db]
+host = replica
+
+[labels]
+team = core
+tier = 1
+`
+
+type testPool struct {
+	Size int `ini:"size"`
+}
+
+type testCommon struct {
+	Host string `ini:"host"`
+}
+
+type testDatabase struct {
+	testCommon
+	Port     uint16         `ini:"port"`
+	Timeout  time.Duration  `ini:"timeout"`
+	Hosts    []string       `ini:"hosts"`
+	Limits   map[string]int `ini:"limits"`
+	Addr     net.IP         `ini:"addr"`
+	URL      *string        `ini:"url"`
+	Missing  *int           `ini:"missing"`
+	Pool     *testPool      `ini:"db.pool"`
+	Untagged string
+	ignored  string
+}
+
+type testSettings struct {
+	Name   string
+	Ratio  float64
+	DB     testDatabase      `ini:"db"`
+	Labels map[string]string `ini:"labels"`
+	Skip   string            `ini:"-"`
+	Kept   string            `ini:"kept"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_decode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := testSettings{Kept: "as is", Skip: "skipped"}
+	if err := Unmarshal(config, &settings); err != nil {
+		t.Fatal(err)
+	}
+
+	url := "postgres://localhost:5432"
+	expect := testSettings{
+		Name:  "service",
+		Ratio: 0.5,
+		DB: testDatabase{
+			testCommon: testCommon{Host: "localhost"},
+			Port:       5432,
+			Timeout:    90 * time.Second,
+			Hosts:      []string{"a", "b", "c"},
+			Limits:     map[string]int{"read": 10, "write": 5},
+			Addr:       net.ParseIP("127.0.0.1"),
+			URL:        &url,
+			Pool:       &testPool{Size: 20},
+		},
+		Labels: map[string]string{"team": "core", "tier": "1"},
+		Skip:   "skipped",
+		Kept:   "as is",
+	}
+	if !reflect.DeepEqual(settings, expect) {
+		t.Errorf("Unmarshal gave\n%+v\nshould be\n%+v", settings, expect)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_decode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var db testDatabase
+	if err := config.Decode("replica", &db); err != nil {
+		t.Fatal(err)
+	}
+	if db.Host != "replica" || db.Port != 5432 {
+		t.Errorf("Decode did not use inherited values: %+v", db)
+	}
+
+	labels := make(map[string]int)
+	err = config.Decode("labels", &labels)
+	var cerr *ConversionError
+	if !errors.As(err, &cerr) || cerr.Option != "team" {
+		t.Errorf("Expected a conversion error for 'team', got %v", err)
+	}
+	if labels["tier"] != 1 {
+		t.Errorf("Good values should still be set: %v", labels)
+	}
+
+	if err := config.Decode("nothere", &db); !errors.Is(err, ErrSectionNotFound) {
+		t.Errorf("Expected ErrSectionNotFound, got %v", err)
+	}
+	if err := config.Decode("db", db); !errors.Is(err, ErrInvalidTarget) {
+		t.Errorf("Expected ErrInvalidTarget, got %v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	config, err := NewConfigurationFromIniString(`
+[db]
+port = many
+timeout = soon
+hosts = ${nothere}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var db struct {
+		Port    int           `ini:"port"`
+		Timeout time.Duration `ini:"timeout"`
+		Hosts   []string      `ini:"hosts"`
+	}
+	err = config.Decode("db", &db)
+	if err == nil {
+		t.Fatal("Expected errors from Decode")
+	}
+
+	// Every bad value is reported, naming the section, option and field
+	for _, expect := range []string{
+		"[db] port = 'many' to int for field Port",
+		"[db] timeout = 'soon' to time.Duration for field Timeout",
+		"Field Hosts: Cannot replace ${nothere} in [db] hosts",
+	} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("Error should contain %q:\n%v", expect, err)
+		}
+	}
+	var ierr *InterpolationError
+	if !errors.As(err, &ierr) {
+		t.Errorf("Expected an *InterpolationError within %v", err)
+	}
+}
+
+type testNode struct {
+	Name  string    `ini:"name"`
+	Child *testNode `ini:"node"`
+}
+
+type testHidden struct {
+	Host string `ini:"host"`
+}
+
+type testOuter struct {
+	*testHidden
+	Port int `ini:"port"`
+}
+
+func TestDecodeOddTypes(t *testing.T) {
+	config, err := NewConfigurationFromIniString("name = root\nhost = h\nport = 1\n[node]\nname = child\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A type that refers to itself is only filled in once from a section
+	var root testNode
+	if err := Unmarshal(config, &root); err != nil {
+		t.Fatal(err)
+	}
+	if root.Name != "root" || root.Child == nil || root.Child.Name != "child" || root.Child.Child != nil {
+		t.Errorf("Wrong tree: %+v", root)
+	}
+
+	// An embedded pointer to an unexported type can't be allocated
+	var outer testOuter
+	if err := Unmarshal(config, &outer); err == nil || !strings.Contains(err.Error(), "Field testOuter.testHidden") {
+		t.Errorf("Expected an error for the embedded pointer, got %v", err)
+	}
+	if outer.Port != 1 {
+		t.Errorf("Other fields should still be set: %+v", outer)
+	}
+	outer.testHidden = &testHidden{}
+	if err := Unmarshal(config, &outer); err != nil || outer.Host != "h" {
+		t.Errorf("A set embedded pointer should be filled in: %+v, %v", outer.testHidden, err)
+	}
+}
+
+type testDefaults struct {
+	Name  string `ini:"name" default:"anon"`
+	Cache struct {
+		Size int           `ini:"size" default:"64"`
+		TTL  time.Duration `ini:"ttl" default:"1m"`
+	} `ini:"cache"`
+	DB struct {
+		Host  string   `ini:"host" default:"localhost"`
+		Port  int      `ini:"port" default:"5432"`
+		Hosts []string `ini:"hosts" default:"a, b"`
+	} `ini:"db"`
+}
+
+func TestDecodeDefaults(t *testing.T) {
+	config, err := NewConfigurationFromIniString("[db]\nhost = remote\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.GetStringWithDefault("db", "user", "admin")
+
+	var settings testDefaults
+	if err := Unmarshal(config, &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Name != "anon" || settings.DB.Host != "remote" || settings.DB.Port != 5432 ||
+		!reflect.DeepEqual(settings.DB.Hosts, []string{"a", "b"}) ||
+		settings.Cache.Size != 64 || settings.Cache.TTL != time.Minute {
+		t.Errorf("Defaults were not used: %+v", settings)
+	}
+
+	// The defaults are recorded along with those from GetStringWithDefault
+	expect := map[string][]string{
+		"default": {"name"},
+		"db":      {"user", "port", "hosts"},
+		"cache":   {"size", "ttl"},
+	}
+	if report := config.DefaultedOptions(); !reflect.DeepEqual(report, expect) {
+		t.Errorf("Defaulted options were %v, should be %v", report, expect)
+	}
+	if config.IsOption("db", "port") {
+		t.Errorf("Defaults should only be added to the section with SetAddOnDefault")
+	}
+
+	// With SetAddOnDefault they become part of the configuration
+	config.SetAddOnDefault(true)
+	if err := config.Decode("db", &settings.DB); err != nil {
+		t.Fatal(err)
+	}
+	if port, _ := config.GetString("db", "port"); port != "5432" {
+		t.Errorf("Default port was not added to the section: %q", port)
+	}
+
+	// A quoted default is read the same way for the field and the section
+	var quoted struct {
+		Prompt string `ini:"prompt" default:"\" > \""`
+	}
+	if err := config.Decode("db", &quoted); err != nil {
+		t.Fatal(err)
+	}
+	if prompt, _ := config.GetString("db", "prompt"); quoted.Prompt != " > " || prompt != quoted.Prompt {
+		t.Errorf("Quoted default gave field %q and option %q, both should be %q", quoted.Prompt, prompt, " > ")
+	}
+
+	// A snapshot uses the defaults without recording them
+	snap, _ := NewConfigurationFromIniString("[db]\nhost = remote\n")
+	var fromSnap testDefaults
+	if err := snap.Snapshot().Unmarshal(&fromSnap); err != nil {
+		t.Fatal(err)
+	}
+	if fromSnap.DB.Port != 5432 || len(snap.DefaultedOptions()) != 0 {
+		t.Errorf("Snapshot defaults: %+v, recorded %v", fromSnap, snap.DefaultedOptions())
+	}
+
+	// A bad default is reported like a bad value
+	var bad struct {
+		Host string `ini:"host" default:"many"`
+		Port int    `ini:"retries" default:"many"`
+	}
+	var cerr *ConversionError
+	if err := config.Decode("db", &bad); !errors.As(err, &cerr) || cerr.Value != "many" || bad.Host != "remote" {
+		t.Errorf("Expected a conversion error for the default, got %v", err)
+	}
+}
+
+type testValidated struct {
+	DB struct {
+		Host    string        `ini:"host" validate:"required"`
+		Port    int           `ini:"port" validate:"required,min=1,max=65535"`
+		Mode    string        `ini:"mode" validate:"oneof=ro rw" default:"ro"`
+		Hosts   []string      `ini:"hosts" validate:"min=1,max=2,pattern=^[a-z.]+$"`
+		Timeout time.Duration `ini:"timeout" validate:"min=1s"`
+		Name    string        `ini:"name" validate:"min=3"`
+	} `ini:"db"`
+	Metrics struct {
+		Port int `ini:"port"`
+	} `ini:"metrics" validate:"required"`
+}
+
+func TestDecodeValidate(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app.ini": "[db]\nport = 70000\nhosts = a.b, C, d\ntimeout = 10ms\nname = ok!\n",
+	})
+	file := filepath.Join(dir, "app.ini")
+	config, err := NewConfigurationFromIniFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var settings testValidated
+	err = Unmarshal(config, &settings)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+	expect := []string{
+		file + ": [db] host (field testValidated.DB.Host): Required but not set",
+		file + ":2: [db] port = '70000' (field testValidated.DB.Port): Out of range: must be at most 65535",
+		file + ":3: [db] hosts = 'a.b, C, d' (field testValidated.DB.Hosts): Out of range: must have at most 2 items",
+		file + ":3: [db] hosts = 'a.b, C, d' (field testValidated.DB.Hosts): Does not match the pattern: ^[a-z.]+$",
+		file + ":4: [db] timeout = '10ms' (field testValidated.DB.Timeout): Out of range: must be at least 1s",
+		file + ": [metrics] (field testValidated.Metrics): Required but not set",
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("Expected %d errors, got:\n%v", len(expect), err)
+	}
+	for i, e := range errs {
+		if e.Error() != expect[i] {
+			t.Errorf("Error %d was\n%s\nshould be\n%s", i, e.Error(), expect[i])
+		}
+	}
+
+	// The values are still filled in
+	if settings.DB.Port != 70000 || settings.DB.Mode != "ro" {
+		t.Errorf("Values should be set even when they break a rule: %+v", settings.DB)
+	}
+
+	// Conversion errors and bad rules are reported along with the violations
+	var bad struct {
+		Port int    `ini:"port" validate:"required"`
+		Name string `ini:"name" validate:"max=many"`
+		Mode string `ini:"mode" validate:"oneof=ro rw" default:"rx"`
+	}
+	config.SetString("db", "port", "many")
+	err = config.Decode("db", &bad)
+	var cerr *ConversionError
+	if !errors.As(err, &cerr) || !errors.Is(err, ErrInvalidRule) || !errors.Is(err, ErrNotOneOf) {
+		t.Errorf("Expected a conversion error, a bad rule and a bad default, got %v", err)
+	}
+
+	config.SetString("db", "port", "5432")
+	config.SetString("db", "host", "localhost")
+	config.SetString("db", "hosts", "a.b")
+	config.SetString("db", "timeout", "1m")
+	config.SetString("db", "name", "main")
+	config.AddSection("metrics")
+	if err := config.Snapshot().Unmarshal(&settings); err != nil {
+		t.Errorf("Settings should be valid: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"sort"
+)
+
+// Change is a single option that differs between two configurations
+type Change struct {
+	Section string
+	Option  string
+	Old     string // "" if the option was added
+	New     string // "" if the option was removed
+}
+
+// Changes holds the differences between two configurations, each list
+// sorted by section and then option.
+type Changes struct {
+	Added    []Change
+	Removed  []Change
+	Modified []Change
+}
+
+// IsEmpty returns true if nothing changed
+func (changes Changes) IsEmpty() bool {
+	return len(changes.Added) == 0 && len(changes.Removed) == 0 && len(changes.Modified) == 0
+}
+
+// Diff will compare two configurations and return the options that were
+// added, removed or changed going from a to b. The values compared are
+// the ones GetString would return, so an option that a section inherits
+// is changed when its parent's value is. The '_default' bookkeeping
+// section is ignored. Either configuration may be nil.
+func Diff(a, b *Configuration) Changes {
+	var changes Changes
+	before, after := a.values(), b.values()
+
+	for section, options := range after {
+		for option, value := range options {
+			old, found := before[section][option]
+			switch {
+			case !found:
+				changes.Added = append(changes.Added, Change{Section: section, Option: option, New: value})
+			case old != value:
+				changes.Modified = append(changes.Modified, Change{Section: section, Option: option, Old: old, New: value})
+			}
+		}
+	}
+	for section, options := range before {
+		for option, value := range options {
+			if _, found := after[section][option]; !found {
+				changes.Removed = append(changes.Removed, Change{Section: section, Option: option, Old: value})
+			}
+		}
+	}
+
+	sortChanges(changes.Added)
+	sortChanges(changes.Removed)
+	sortChanges(changes.Modified)
+	return changes
+}
+
+// values returns every option of every section, including the inherited
+// ones, as GetString would return them. A value with a bad reference is
+// used as written.
+func (config *Configuration) values() map[string]ConfigOption {
+	values := make(map[string]ConfigOption)
+	if config == nil {
+		return values
+	}
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	for _, section := range config.sectionNames() {
+		if section == "_default" {
+			continue
+		}
+		options := make(ConfigOption)
+		config.mergeInto(options, section, make(map[string]bool))
+		for option, value := range options {
+			if expanded, err := config.expand(section, option, value, nil); err == nil {
+				options[option] = expanded
+			}
+		}
+		values[section] = options
+	}
+	return values
+}
+
+// sortChanges sorts a list of changes by section and then option
+func sortChanges(changes []Change) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Section != changes[j].Section {
+			return changes[i].Section < changes[j].Section
+		}
+		return changes[i].Option < changes[j].Option
+	})
+}
+
+// changeHandler is a function registered with OnChange
+type changeHandler struct {
+	section string
+	option  string
+	fn      func(old, new string)
+}
+
+// OnChange will call fn each time a new configuration is stored and the
+// value of the option in the section is added, removed or changed. The
+// old or new value is "" when the option didn't exist. The handlers are
+// called, in the order they were added, by the goroutine calling Store,
+// and must not call Store themselves.
+func (holder *Holder) OnChange(sectionName, optionName string, fn func(old, new string)) {
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	holder.handlers = append(holder.handlers, changeHandler{
+		section: conformSectionName(sectionName),
+		option:  conformOption(optionName),
+		fn:      fn,
+	})
+}
+
+// notify calls the handlers for any options that changed. The caller
+// must hold holder.mu.
+func (holder *Holder) notify(old, current *Snapshot) {
+	if len(holder.handlers) == 0 || old == nil {
+		return
+	}
+	changes := Diff(old.config, current.config)
+	for _, list := range [][]Change{changes.Added, changes.Removed, changes.Modified} {
+		for _, change := range list {
+			for _, handler := range holder.handlers {
+				if handler.section == change.Section && handler.option == change.Option {
+					handler.fn(change.Old, change.New)
+				}
+			}
+		}
+	}
+}
+
+// OnChange will call fn each time a reload changes the option in the
+// section. See Holder.OnChange.
+func (w *Watcher) OnChange(sectionName, optionName string, fn func(old, new string)) {
+	w.holder.OnChange(sectionName, optionName, fn)
+}
@@ -0,0 +1,105 @@
+package gofig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := NewConfigurationFromIniString(`
+[pool]
+size = 10
+name = main
+url = ${name}:${size}
+
+[db]
+host = localhost
+port = 5432
+
+[cache : db]
+ttl = 60
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewConfigurationFromIniString(`
+[pool]
+size = 20
+name = main
+url = ${name}:${size}
+
+[db]
+host = localhost
+user = admin
+
+[cache : db]
+ttl = 60
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.GetStringWithDefault("db", "missing", "x") // Recorded in _default, not a change
+
+	changes := Diff(a, b)
+	added := []Change{
+		{Section: "cache", Option: "user", New: "admin"},
+		{Section: "db", Option: "user", New: "admin"},
+	}
+	removed := []Change{
+		{Section: "cache", Option: "port", Old: "5432"},
+		{Section: "db", Option: "port", Old: "5432"},
+	}
+	modified := []Change{
+		{Section: "pool", Option: "size", Old: "10", New: "20"},
+		{Section: "pool", Option: "url", Old: "main:10", New: "main:20"},
+	}
+	if !reflect.DeepEqual(changes.Added, added) {
+		t.Errorf("Added was %v, should be %v", changes.Added, added)
+	}
+	if !reflect.DeepEqual(changes.Removed, removed) {
+		t.Errorf("Removed was %v, should be %v", changes.Removed, removed)
+	}
+	if !reflect.DeepEqual(changes.Modified, modified) {
+		t.Errorf("Modified was %v, should be %v", changes.Modified, modified)
+	}
+
+	if !Diff(a, a).IsEmpty() {
+		t.Errorf("A configuration should not differ from itself: %v", Diff(a, a))
+	}
+	if all := Diff(nil, b); len(all.Added) != 8 || len(all.Removed) != 0 {
+		t.Errorf("Diff from nil should add every option: %v", all)
+	}
+}
+
+func TestHolderOnChange(t *testing.T) {
+	config, err := NewConfigurationFromIniString("[pool]\nsize = 10\n[db]\nhost = localhost\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder := NewHolder(config)
+
+	var calls []string
+	holder.OnChange("pool", "size", func(old, new string) {
+		calls = append(calls, "size "+old+" -> "+new)
+	})
+	holder.OnChange(" db ", "timeout", func(old, new string) {
+		calls = append(calls, "timeout "+old+" -> "+new)
+	})
+
+	config.SetString("db", "host", "remote") // No handler for this one
+	holder.Store(config)
+	if len(calls) != 0 {
+		t.Errorf("Handlers were called for options that didn't change: %v", calls)
+	}
+
+	config.SetString("pool", "size", "20")
+	config.SetString("db", "timeout", "5")
+	holder.Store(config)
+	config.DeleteOption("db", "timeout")
+	holder.Store(config)
+
+	expect := []string{"timeout  -> 5", "size 10 -> 20", "timeout 5 -> "}
+	if !reflect.DeepEqual(calls, expect) {
+		t.Errorf("Handlers were called with %q, should be %q", calls, expect)
+	}
+}
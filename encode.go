@@ -0,0 +1,228 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Marshal uses the same 'ini' tags as Unmarshal, with these extra options:
+//
+//   Port  int               `ini:"port,omitempty"`      // skipped if zero
+//   Tags  map[string]string `ini:"tags,section"`        // written as [tags]
+//   Debug bool              `ini:"debug" comment:"Turn on logging"`
+//
+// The comment tag is written on the line before the option, or before the
+// section line for a section. Maps are written as a single option unless
+// 'section' is given. Nil pointers are skipped.
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// Marshal will build a configuration from the struct v, or a pointer to
+// it. Its fields become options in the 'default' section and its struct
+// fields become sections. Write the result out with WriteTo. Any '$' or
+// '%' in a value is doubled so it isn't read as a reference. An error is
+// returned for a list or map item holding a ',' (or a map key holding an
+// '='), as it couldn't be read back in.
+func Marshal(v any) (*Configuration, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, ErrInvalidTarget
+	}
+	if !rv.CanAddr() { // So MarshalText with a pointer receiver can be used
+		copied := reflect.New(rv.Type()).Elem()
+		copied.Set(rv)
+		rv = copied
+	}
+	e := &encoder{config: NewConfiguration()}
+	e.config.IsLoaded = true
+	e.encodeStruct("default", rv, rv.Type().Name())
+	if len(e.errs) > 0 {
+		return nil, errors.Join(e.errs...)
+	}
+	return e.config, nil
+}
+
+// encoder collects the errors found while building a configuration
+type encoder struct {
+	config *Configuration
+	errs   []error
+}
+
+// hasOption returns true if the tag has the option (omitempty, ...)
+func (f field) hasOption(option string) bool {
+	for _, o := range f.options {
+		if strings.TrimSpace(o) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeStruct adds the fields of a struct to a section
+func (e *encoder) encodeStruct(sectionName string, rv reflect.Value, path string) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		f := fieldInfo(sf)
+		if f.skip {
+			continue
+		}
+		fv := rv.Field(i)
+		fieldPath := sf.Name
+		if path != "" {
+			fieldPath = path + "." + sf.Name
+		}
+		if (fv.Kind() == reflect.Pointer || fv.Kind() == reflect.Interface) && fv.IsNil() {
+			continue
+		}
+		if f.hasOption("omitempty") && isEmpty(fv) {
+			continue
+		}
+		comment := sf.Tag.Get("comment")
+
+		switch {
+		case f.flatten:
+			e.encodeStruct(sectionName, reflect.Indirect(fv), fieldPath)
+		case f.isSection:
+			e.config.addSection(f.name)
+			e.config.setComment(f.name, "", comment)
+			e.encodeStruct(f.name, reflect.Indirect(fv), fieldPath)
+		case fv.Kind() == reflect.Map && f.hasOption("section"):
+			e.config.addSection(f.name)
+			e.config.setComment(f.name, "", comment)
+			e.encodeSection(f.name, fv, fieldPath)
+		default:
+			value, err := formatValue(fv)
+			if err != nil {
+				e.errs = append(e.errs, fmt.Errorf("Field %s: %w", fieldPath, err))
+				continue
+			}
+			e.config.setString(sectionName, f.name, Origin{Value: escapeReferences(value)})
+			e.config.setComment(sectionName, f.name, comment)
+		}
+	}
+}
+
+// encodeSection adds each entry of a map as an option, sorted by name
+func (e *encoder) encodeSection(sectionName string, fv reflect.Value, path string) {
+	options := make(ConfigOption, fv.Len())
+	for _, key := range fv.MapKeys() {
+		name, err := formatValue(key)
+		if err == nil {
+			options[name], err = formatValue(fv.MapIndex(key))
+		}
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("Field %s: %w", path, err))
+			return
+		}
+	}
+	for _, name := range orderedNames(nil, options) {
+		e.config.setString(sectionName, name, Origin{Value: escapeReferences(options[name])})
+	}
+}
+
+// isEmpty returns true if the value should be left out with omitempty
+func isEmpty(fv reflect.Value) bool {
+	switch fv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return fv.Len() == 0
+	}
+	return fv.IsZero()
+}
+
+// formatValue is the reverse of setValue: it converts a value to the
+// string that setValue would read back in.
+func formatValue(fv reflect.Value) (string, error) {
+	if fv.Kind() == reflect.Pointer || fv.Kind() == reflect.Interface {
+		if fv.IsNil() {
+			return "", nil
+		}
+		return formatValue(fv.Elem())
+	}
+	if fv.Type().Implements(textMarshalerType) {
+		text, err := fv.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+	if fv.CanAddr() && fv.Addr().Type().Implements(textMarshalerType) {
+		text, err := fv.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+	if fv.Type() == durationType {
+		return time.Duration(fv.Int()).String(), nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'g', -1, fv.Type().Bits()), nil
+	case reflect.Complex64, reflect.Complex128:
+		return strconv.FormatComplex(fv.Complex(), 'g', -1, fv.Type().Bits()), nil
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(fv.Bytes()), nil
+		}
+		items := make([]string, fv.Len())
+		for i := range items {
+			item, err := formatValue(fv.Index(i))
+			if err == nil {
+				err = checkItem(item, ",")
+			}
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return strings.Join(items, ", "), nil
+	case reflect.Map:
+		items := make([]string, 0, fv.Len())
+		for _, key := range fv.MapKeys() {
+			k, err := formatValue(key)
+			if err == nil {
+				err = checkItem(k, ",=")
+			}
+			if err != nil {
+				return "", err
+			}
+			v, err := formatValue(fv.MapIndex(key))
+			if err == nil {
+				err = checkItem(v, ",")
+			}
+			if err != nil {
+				return "", err
+			}
+			items = append(items, k+" = "+v)
+		}
+		sort.Strings(items)
+		return strings.Join(items, ", "), nil
+	}
+	return "", fmt.Errorf("Unsupported type %s", fv.Type())
+}
+
+// checkItem returns an error if a list or map item holds one of the
+// separators, as splitList would break it up when it is read back in.
+func checkItem(item, separators string) error {
+	if i := strings.IndexAny(item, separators); i >= 0 {
+		return fmt.Errorf("Item '%s' contains the separator '%c'", item, item[i])
+	}
+	return nil
+}
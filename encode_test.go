@@ -0,0 +1,178 @@
+package gofig
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	Host    string        `ini:"host" comment:"Name or address to listen on"`
+	Port    int           `ini:"port,omitempty"`
+	Timeout time.Duration `ini:"timeout"`
+	Allow   []net.IP      `ini:"allow,omitempty"`
+	Pool    *testPool     `ini:"server.pool" comment:"Connection pool"`
+}
+
+type testAppConfig struct {
+	Name   string            `ini:"name" comment:"Service name\nused in logs"`
+	Debug  bool              `ini:"debug,omitempty"`
+	Server testServer        `ini:"server"`
+	Tags   map[string]string `ini:"tags,section"`
+	Limits map[string]int    `ini:"limits"`
+	Cache  *testPool         `ini:"cache"`
+	Secret string            `ini:"-"`
+}
+
+func TestMarshal(t *testing.T) {
+	app := testAppConfig{
+		Name: "svc",
+		Server: testServer{
+			Host:    "0.0.0.0",
+			Timeout: 5 * time.Second,
+			Allow:   []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")},
+			Pool:    &testPool{Size: 4},
+		},
+		Tags:   map[string]string{"team": "core", "env": "prod"},
+		Limits: map[string]int{"write": 5, "read": 10},
+		Secret: "hidden",
+	}
+	config, err := Marshal(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if _, err := config.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	expect := `; Service name
+; used in logs
+name = svc
+limits = read = 10, write = 5
+
+[server]
+; Name or address to listen on
+host = 0.0.0.0
+timeout = 5s
+allow = 10.0.0.1, 10.0.0.2
+
+; Connection pool
+[server.pool]
+size = 4
+
+[tags]
+env = prod
+team = core
+`
+	if out.String() != expect {
+		t.Errorf("Marshal wrote:\n%s\nbut should have written:\n%s", out.String(), expect)
+	}
+
+	// And it reads back in as it was
+	again, err := NewConfigurationFromIniString(out.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back testAppConfig
+	if err := Unmarshal(again, &back); err != nil {
+		t.Fatal(err)
+	}
+	app.Secret = ""
+	if !reflect.DeepEqual(back, app) {
+		t.Errorf("Read back as\n%+v\nrather than\n%+v", back, app)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	if _, err := Marshal("text"); !errors.Is(err, ErrInvalidTarget) {
+		t.Errorf("Expected ErrInvalidTarget, got %v", err)
+	}
+	_, err := Marshal(&struct {
+		Handler func() `ini:"handler"`
+	}{Handler: func() {}})
+	if err == nil || err.Error() != "Field Handler: Unsupported type func()" {
+		t.Errorf("Expected an unsupported type error, got %v", err)
+	}
+	_, err = Marshal(&struct {
+		Names []string `ini:"names"`
+	}{Names: []string{"Smith, J", "Doe"}})
+	if err == nil || err.Error() != "Field Names: Item 'Smith, J' contains the separator ','" {
+		t.Errorf("Expected a separator error, got %v", err)
+	}
+	_, err = Marshal(&struct {
+		Env map[string]string `ini:"env"`
+	}{Env: map[string]string{"opts": "a=b"}})
+	if err != nil {
+		t.Errorf("Expected '=' in a map value to be allowed, got %v", err)
+	}
+	_, err = Marshal(&struct {
+		Env map[string]string `ini:"env"`
+	}{Env: map[string]string{"a=b": "c"}})
+	if err == nil || err.Error() != "Field Env: Item 'a=b' contains the separator '='" {
+		t.Errorf("Expected a separator error, got %v", err)
+	}
+}
+
+func TestMarshalListRoundTrip(t *testing.T) {
+	type values struct {
+		Names []string          `ini:"names"`
+		Env   map[string]string `ini:"env"`
+	}
+	want := values{
+		Names: []string{"a b", "c"},
+		Env:   map[string]string{"opts": "x=1", "home": "/root"},
+	}
+	config, err := Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got values
+	if err := Unmarshal(config, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Round trip gave %+v, want %+v", got, want)
+	}
+}
+
+func TestMarshalReferences(t *testing.T) {
+	type values struct {
+		Price string            `ini:"price"`
+		Tmpl  string            `ini:"tmpl"`
+		Py    string            `ini:"py"`
+		Tags  map[string]string `ini:"tags,section"`
+	}
+	app := values{
+		Price: "$$5 100%%",
+		Tmpl:  "${HOME}/x $",
+		Py:    "%(x)s 50%",
+		Tags:  map[string]string{"env": "${env:USER}"},
+	}
+	config, err := Marshal(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSection(t, config, "default", "price", app.Price)
+	checkSection(t, config, "default", "tmpl", app.Tmpl)
+	checkSection(t, config, "tags", "env", "${env:USER}")
+
+	var out bytes.Buffer
+	if _, err := config.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewConfigurationFromIniString(out.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back values
+	if err := Unmarshal(again, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, app) {
+		t.Errorf("Read back as\n%+v\nrather than\n%+v", back, app)
+	}
+}
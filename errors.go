@@ -0,0 +1,88 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Errors returned while parsing. They are normally wrapped inside of a
+// ParseError so use errors.Is to test for them.
+var (
+	ErrEmptyInput      = errors.New("String cannot be empty")
+	ErrInvalidSection  = errors.New("Invalid section marker")
+	ErrInvalidKeyValue = errors.New("Invalid key/value pair")
+	ErrIncludeCycle    = errors.New("Include cycle")
+	ErrIncludeDepth    = errors.New("Includes nested too deeply")
+
+	// Returned when ParseOptions.KeepDocument is used with InterpolateEager,
+	// as the references in the document would be lost when it is written
+	ErrKeepDocumentEager = errors.New("KeepDocument can't be used with InterpolateEager")
+)
+
+// Errors returned when looking up values. They are wrapped with the
+// name of the section or option that could not be found.
+var (
+	ErrSectionNotFound = errors.New("Section not found")
+	ErrOptionNotFound  = errors.New("Option not found")
+)
+
+// ParseError describes a problem found in a single line of the input.
+// Use errors.As to get at the details and errors.Is to check the Reason.
+type ParseError struct {
+	File   string // Name of the file, or "" when parsed from a string
+	Line   int    // Line number, starting at 1
+	Column int    // Column within the line, starting at 1
+	Text   string // The raw text of the line
+	Reason error  // Why the line was rejected (ErrInvalidSection, ...)
+
+	// Include directives that led to File, outermost first, as 'file:line'
+	Includes []string
+}
+
+// Error will format the error as 'file:line:column: reason in line: text'
+func (e *ParseError) Error() string {
+	where := "line " + strconv.Itoa(e.Line) + ", column " + strconv.Itoa(e.Column)
+	if e.File != "" {
+		where = e.File + ":" + strconv.Itoa(e.Line) + ":" + strconv.Itoa(e.Column)
+	}
+	msg := where + ": " + e.Reason.Error() + " in line: " + e.Text
+	if len(e.Includes) > 0 {
+		msg += " (included from " + strings.Join(e.Includes, " -> ") + ")"
+	}
+	return msg
+}
+
+// Unwrap returns the reason so errors.Is can match the sentinel errors
+func (e *ParseError) Unwrap() error {
+	return e.Reason
+}
+
+// ConversionError is returned when a value exists but cannot be converted
+// to the type that was asked for (e.g. GetInt on 'abc').
+type ConversionError struct {
+	Section string // Section that was searched
+	Option  string // Option that was found
+	Value   string // The raw value that could not be converted
+	Type    string // What we tried to convert to: "int", "bool", ...
+	Field   string // The struct field being filled in by Unmarshal, if any
+	Err     error  // The error from the conversion routine
+}
+
+// Error will format the error with the section, option and value.
+func (e *ConversionError) Error() string {
+	msg := "Cannot convert [" + e.Section + "] " + e.Option + " = '" + e.Value + "' to " + e.Type
+	if e.Field != "" {
+		msg += " for field " + e.Field
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying conversion error
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
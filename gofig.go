@@ -18,29 +18,44 @@
 //   #
 //   [ db ]
 //   db="postgres"
-//   host = remotehost
+//   host = remotehost   ; comments can follow a value
 //   #
 //   [testdb : db ]
 //   host=localhost
 //   #
+//   url = postgres://${host}/%(db)s
+//   include conf.d/*.ini
 // testdb will inherit from db. You can have as man inheritence as you like. They are
-// evaluated from left to right.
+// evaluated from left to right. The parents are searched when an option is looked up,
+// so they can be defined after the section that inherits from them.
 // Spaces and quotes are stripped and ignored.
 // if you want to have quotes, you can double them: ""value"" will give "value"
+// Double-quoted values can hold escapes: "line 1\nline 2\t\"quoted\"".
+// Single-quoted values are used exactly as written: 'C:\new\'.
+//
+// Long values can be split over several lines by ending a line with a '\',
+// or with python-style indented lines. Both are turned on with
+// ParseOptions.Continuation.
+//
+// Values can refer to other options with ${key}, ${section:key} or %(key)s.
+// Environment variables are used with ${env:NAME} or ${NAME:-default}.
+// Use $$ or %% for a literal '$' or '%'.
 //
 // To get an option, you would call GetString( "testdb" , "db" )
 // To get a numeric option, you would use GetInt. For booleans, use GetBool
+// To fill in a whole struct, tagged with `ini:"name"`, use Unmarshal or Decode.
+// Marshal does the reverse, building a configuration from a struct.
 //
 package gofig
 
 import (
-	"bufio"
 	"errors"
 	//"fmt"
 	"io"
 	"os"
 	"strings"
 	"strconv"
+	"sync"
 )
 
 const (
@@ -58,12 +73,26 @@ type ConfigOption map[string]string // Single line config
 // GOB cache files are automatically created when requested and used
 // if the GOB is newer than the human-readable file
 
+//
+// The methods of a Configuration are safe for use by several goroutines
+// at once. The exported fields (ConfigMap, Inheritance, SectionOrder,
+// OptionOrder, ...) are not: only touch them directly while no other
+// goroutine is using the configuration.
 //
 type Configuration struct {
+	// Guards everything below
+	mu sync.RWMutex
 
 	// Contains the section => option map
 	ConfigMap map[string]ConfigOption
 
+	// Contains the section => parent sections it inherits from
+	Inheritance map[string][]string
+
+	// The order the sections and each section's options were defined in
+	SectionOrder []string
+	OptionOrder  map[string][]string
+
 	// How many sections are filled in
 	Sections int
 
@@ -84,17 +113,43 @@ type Configuration struct {
 
 	// Name of the cache file used
 	cacheFile string
+
+	// Every file that was read, including those from include directives
+	sources []string
+
+	// The globs used by include directives, so new matches can be seen
+	globs []string
+
+	// When references to other options (${key}) are replaced
+	interpolation Interpolation
+
+	// The original file, when it is kept so it can be written back out
+	document *document
+
+	// Where each value came from: section => option => history
+	origins map[string]map[string][]Origin
+
+	// Comments written before options, or sections (option ""), by WriteTo
+	comments map[string]map[string]string
+
+	// How ${env:NAME} references are found, and if they must be set
+	lookupEnv func(string) (string, bool)
+	strictEnv bool
 }
 
 // OnDefaultAddToSection will set the flag to determine if we should add values into each section
 // when we use a default value.
 func ( config *Configuration ) SetAddOnDefault( flag bool ) *Configuration {
+	config.mu.Lock()
+	defer config.mu.Unlock()
 	config.OnDefaultAddToSection = flag
 	return config
 }
 // IsCacheFileNewer will check to see if a cache file is newer than the main
 // file. If the file doesnt exist, it will be considered 'older'
-func ( config Configuration ) IsCacheFileNewer( ) bool {
+func ( config *Configuration ) IsCacheFileNewer( ) bool {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
 	if "" != config.cacheFile {
 		return false
 	}
@@ -111,6 +166,15 @@ func isCacheFileNewer(file, cacheFile string) bool {
 	return (cacheInfo.ModTime().After( fileInfo.ModTime() ) )
 }
 
+// Sources will return the names of all of the files that were read to
+// build the configuration, starting with the main file and followed
+// by any included files.
+func (config *Configuration) Sources() []string {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return append([]string(nil), config.sources...)
+}
+
 // NewConfigurationFromCache Create a cache file from the cache string
 // return error when the file cannot be opened
 func NewConfigurationFromCache(cacheFile string) (*Configuration, error) {
@@ -127,6 +191,8 @@ func NewConfigurationFromCache(cacheFile string) (*Configuration, error) {
 func NewConfigurationWithCache(file string) *Configuration {
 	config := Configuration{
 		ConfigMap:  make(map[string]ConfigOption, defaultPreAllocate ),
+		Inheritance: make(map[string][]string),
+		OptionOrder: make(map[string][]string),
 		IsLoaded:   false,
 		IsCache:    false,
 		ConfigFile: "",
@@ -143,93 +209,127 @@ func NewConfiguration() *Configuration {
 }
 
 // configFromReader is the internal function that does the actual
-// parsing required for the sections and options.
-func configFromReader(reader io.Reader, cacheName string) (*Configuration, error) {
-	scanner := bufio.NewScanner(reader)
-	var line string
-	section := "default"
-	config := NewConfigurationWithCache(cacheName)
-
-	for scanner.Scan() {
-		line = strings.TrimSpace(scanner.Text())
-		lenLine := len(line)
-		// If we have a non-comment, non-blank line...
-		if lenLine > 0 && line[0:1] != "#" && line[0:1] != ";" {
-			if line[0:1] == "[" {
-				if line[lenLine-1:] != "]" {
-					return nil, errors.New("Invalid section marker in line: " + line)
-				}
-				section = strings.TrimSpace(line[1 : lenLine-1])
-				// Find out if there are any subsections (inheritance)
-				if strings.Contains(section, ":") {
-
-					for i, name := range strings.Split(section, ":") {
-						sectionName := strings.TrimSpace(name)
-						if i == 0 {
-							section = sectionName
-						} else {
-							config.MergeOptions(section, sectionName)
-						}
-					}
-
-				}
-			} else {
-				parts := strings.SplitN(line, "=" , 2)
-				if len(parts) != 2 {
-					return nil, errors.New("Invalid key/value pair in line: " + line)
-				}
-				config.SetString(section, parts[0], parts[1] )
-			}
-		}
+// parsing required for the sections and options. The filename is only
+// used to report where an error was found.
+//
+// In lenient mode, a configuration is returned along with all of
+// the errors that were found, joined together.
+func configFromReader(reader io.Reader, filename string, options ParseOptions) (*Configuration, error) {
+	if options.KeepDocument && options.Interpolation == InterpolateEager {
+		return nil, ErrKeepDocumentEager
+	}
+	config := NewConfigurationWithCache(options.Cache)
+	if options.KeepDocument {
+		config.document = &document{file: filename, options: options, included: make(map[string]bool)}
+	}
+	p := newParser(config, filename, options)
+
+	if err := p.parse(reader); err != nil {
+		return nil, err
 	}
 	config.IsLoaded = true
-	config.SaveCache()
+	if len(p.errs) > 0 { // Don't cache a partial load
+		err := config.applyOptions(options)
+		return config, errors.Join(append(p.errs, err)...)
+	}
+
+	// The cache holds the values as written, as the options are applied
+	// again when it is loaded. It is only saved once they have all been
+	// replaced without error.
+	raw := config
+	if options.Interpolation == InterpolateEager && options.Cache != "" {
+		raw = config.clone() // Not shared yet, so no lock is needed
+	}
+	if err := config.applyOptions(options); err != nil {
+		if !options.Lenient {
+			return nil, err
+		}
+		return config, err
+	}
+	if !p.includes { // Only the main file is checked against the cache
+		raw.SaveCache()
+	}
 	return config, nil
 }
 
+// applyOptions sets up interpolation as the parse options ask. With
+// InterpolateEager every reference is replaced now.
+func (config *Configuration) applyOptions(options ParseOptions) error {
+	config.SetEnvLookup(options.LookupEnv)
+	config.SetStrictEnv(options.StrictEnv)
+	switch options.Interpolation {
+	case InterpolateEager:
+		return config.Interpolate()
+	case InterpolateNone:
+		config.SetInterpolation(false)
+	}
+	return nil
+}
+
 // NewConfigurationFromIniString will create a new configuration from a
-// string rather than using a file. Caching is not used with strings
+// string rather than using a file. Caching is not used with strings.
+// Errors in the input are returned as a *ParseError
 func NewConfigurationFromIniString(input string) (*Configuration, error) {
+	return NewConfigurationFromIniStringWithOptions(input, DefaultParseOptions())
+}
+
+// NewConfigurationFromIniStringWithOptions is NewConfigurationFromIniString
+// but with control over how the string is parsed. The cache is ignored.
+func NewConfigurationFromIniStringWithOptions(input string, options ParseOptions) (*Configuration, error) {
 	if input == "" {
-		return nil, errors.New("String cannot be empty")
+		return nil, ErrEmptyInput
 	}
-	return configFromReader(strings.NewReader(input), "")
+	options.Cache = ""
+	return configFromReader(strings.NewReader(input), "", options)
 }
 
 // NewConfigurationFromIniFile will create a new configuration, read in the
 // the standard ini-style configuration file and return a configuration
 func NewConfigurationFromIniFileWithCache(filename, cache string) (*Configuration, error) {
+	options := DefaultParseOptions()
+	options.Cache = cache
+	return NewConfigurationFromIniFileWithOptions(filename, options)
+}
+
+// NewConfigurationFromIniFileWithOptions will read an ini-style file using
+// the options passed. If options.Cache is set and newer than the file, the
+// cache will be used instead, with the same interpolation options. The
+// cache isn't used when keeping the document, as it doesn't hold one.
+func NewConfigurationFromIniFileWithOptions(filename string, options ParseOptions) (*Configuration, error) {
 
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
-	if cache != "" && isCacheFileNewer(filename, cache) {
-		config, err := NewConfigurationFromCache(cache)
-		if err == nil {
-			return config, err
+	if options.Cache != "" && !options.KeepDocument && isCacheFileNewer(filename, options.Cache) {
+		config, err := NewConfigurationFromCache(options.Cache)
+		if err == nil && config.applyOptions(options) == nil {
+			return config, nil
 		}
 	}
-	return configFromReader(file, cache)
+	return configFromReader(file, filename, options)
 }
 
 // NewConfigurationFromIniFile will open up a filename and parse the ini-style
-// strings from each line found.
+// strings from each line found. Errors in the file are returned as a *ParseError
+// that holds the filename and line number.
 func NewConfigurationFromIniFile(filename string) (*Configuration, error) {
 	return NewConfigurationFromIniFileWithCache(filename, "")
 }
 
 // String will convert the configuration into a nicely printable,
-// indented format
-func (config Configuration) String() string {
+// indented format. Use WriteTo to write it as an ini file
+func (config *Configuration) String() string {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
 	var out string
 
 	out = out + ";\n;  OnDefaultAddToSection is " + strconv.FormatBool( config.OnDefaultAddToSection ) + "\n;\n"
-	for key := range config.ConfigMap {
-		out = out + "[" + key + "]\n"
-		for subkey := range config.ConfigMap[key] {
-			out = out + "\t'" + subkey + "' = '" + config.ConfigMap[key][subkey] + "'\n"
+	for _, key := range config.sectionNames() {
+		out = out + "[" + strings.Join( append( []string{ key } , config.Inheritance[key]... ) , " : " ) + "]\n"
+		for _, subkey := range config.optionNames( key ) {
+			out = out + "\t'" + subkey + "' = \"" + escapeValue(config.ConfigMap[key][subkey]) + "\"\n"
 		}
 	}
 
@@ -4,6 +4,10 @@
 package gofig
 
 import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	//"fmt"
 )
@@ -179,6 +183,81 @@ func TestCascadeSections( t *testing.T){
 
 }
 
+var testdata_cascade_late = `
+[three:two:one]
+e=5
+
+[one]
+a=1
+b=2
+
+[two]
+b=two
+c=3
+`
+
+func TestCascadeSections_Late( t *testing.T){
+	config,err := NewConfigurationFromIniString( testdata_cascade_late )
+
+	if err != nil {
+		t.Fatalf( "Error: could not ini from string: %s" , err )
+	}
+	checkSection( t , config , "three"  , "e" , "5")
+	checkSection( t , config , "three"  , "a" , "1")
+	checkSection( t , config , "three"  , "b" , "2")
+	checkSection( t , config , "three"  , "c" , "3")
+
+	config.SetString( "one" , "f" , "6" )
+	config.SetString( "two" , "c" , "changed" )
+	checkSection( t , config , "three"  , "f" , "6")
+	checkSection( t , config , "three"  , "c" , "changed")
+	if ! config.IsOption( "three" , "f" ) {
+		t.Errorf( "Inherited options should exist" )
+	}
+
+	own,_ := config.GetOwnSection( "three" )
+	merged,_ := config.GetMergedSection( "three" )
+	if len( own ) != 1 || len( merged ) != 5 || merged["b"] != "2" {
+		t.Errorf( "Wrong options in section: %v / %v" , own , merged )
+	}
+	if section,_ := config.GetSection( "three" ) ; ! reflect.DeepEqual( section , merged ) {
+		t.Errorf( "GetSection should include inherited options: %v" , section )
+	}
+}
+
+func TestInheritanceGraph( t *testing.T){
+	config,_ := NewConfigurationFromIniString( testdata_cascade_late + "\n[four:three]\ng=7\n[five:one]\n" )
+
+	if p := config.Parents( "three" ) ; len( p ) != 2 || p[0] != "two" || p[1] != "one" {
+		t.Errorf( "Wrong parents for three: %v" , p )
+	}
+	if a := config.Ancestors( "four" ) ; strings.Join( a , "," ) != "three,one,two" {
+		t.Errorf( "Wrong ancestors for four: %v" , a )
+	}
+	if d := config.Descendants( "one" ) ; strings.Join( d , "," ) != "three,four,five" {
+		t.Errorf( "Wrong descendants for one: %v" , d )
+	}
+
+	if err := config.AddParent( "one" , "four" ) ; ! errors.Is( err , ErrInheritanceCycle ) {
+		t.Errorf( "Expected a cycle error but got %v" , err )
+	}
+	if err := config.AddParent( "one" , "one" ) ; ! errors.Is( err , ErrInheritanceCycle ) {
+		t.Errorf( "Expected a cycle error but got %v" , err )
+	}
+	if err := config.AddParent( "one" , "other" ) ; err != nil {
+		t.Errorf( "Parent should have been added: %s" , err )
+	}
+
+	_,err := NewConfigurationFromIniString( "[a:b]\n[b:c]\n[c:a]\n" )
+	var perr *ParseError
+	if ! errors.Is( err , ErrInheritanceCycle ) || ! errors.As( err , &perr ) || perr.Line != 3 {
+		t.Errorf( "Expected a cycle error on line 3 but got %v" , err )
+	}
+	if _,err = NewConfigurationFromIniString( "[a:a]\n" ) ; ! errors.Is( err , ErrInheritanceCycle ) {
+		t.Errorf( "Expected a cycle error but got %v" , err )
+	}
+}
+
 func TestTypes( t *testing.T){
 	config,err := NewConfigurationFromIniString( testdata_types )
 
@@ -189,7 +268,7 @@ func TestTypes( t *testing.T){
 	// --------- Booleans
 	b,err := config.GetBool("types" , "bool")
 	if b!= true {
-		t.Errorf( "Boolean value was wrong: %b"  , b )
+		t.Errorf( "Boolean value was wrong: %t"  , b )
 	}
 
 	if err != nil {
@@ -215,6 +294,35 @@ func TestTypes( t *testing.T){
 		t.Errorf("Badint value did not trigger an error")
 	}
 }
+func TestLookupErrors( t *testing.T ){
+	config,_ := NewConfigurationFromIniString( testdata_types )
+
+	if _,err := config.GetString( "nosection" , "int" ) ; ! errors.Is( err , ErrSectionNotFound ) {
+		t.Errorf( "Expected ErrSectionNotFound but got %v" , err )
+	}
+	if _,err := config.GetInt( "types" , "noint" ) ; ! errors.Is( err , ErrOptionNotFound ) {
+		t.Errorf( "Expected ErrOptionNotFound but got %v" , err )
+	}
+	if _,err := config.GetBool( "types" , "nobool" ) ; ! errors.Is( err , ErrOptionNotFound ) {
+		t.Errorf( "Expected ErrOptionNotFound but got %v" , err )
+	}
+
+	_,err := config.GetInt( "types" , "badint" )
+	var cerr *ConversionError
+	if ! errors.As( err , &cerr ) {
+		t.Fatalf( "Expected a ConversionError but got %v" , err )
+	}
+	if cerr.Section != "types" || cerr.Option != "badint" || cerr.Value != "10.654" || cerr.Type != "int" {
+		t.Errorf( "ConversionError has wrong values: %#v" , cerr )
+	}
+	if ! errors.Is( err , strconv.ErrSyntax ) {
+		t.Errorf( "ConversionError should wrap the strconv error: %s" , err )
+	}
+	if _,err = config.GetBoolWithDefault( "types" , "badbool" , true ) ; ! errors.As( err , &cerr ) || cerr.Type != "bool" {
+		t.Errorf( "Expected a bool ConversionError but got %v" , err )
+	}
+}
+
 func TestBadSection( t * testing.T ){
 	_,err := NewConfigurationFromIniString( testdata_badsection )
 
@@ -230,6 +338,207 @@ func TestBadSectionMarker( t * testing.T ){
 		t.Errorf( "Invalid section did not trigger an error")
 	}
 }
+func TestParseError( t *testing.T ){
+	_,err := NewConfigurationFromIniString( testdata_badsection )
+
+	var perr *ParseError
+	if ! errors.As( err , &perr ) {
+		t.Fatalf( "Expected a ParseError but got %v" , err )
+	}
+	if perr.Line != 2 || perr.Column != 9 || perr.Text != "[section" {
+		t.Errorf( "Wrong position reported: %d:%d '%s'" , perr.Line , perr.Column , perr.Text )
+	}
+	if ! errors.Is( err , ErrInvalidSection ) {
+		t.Errorf( "Error should match ErrInvalidSection: %s" , err )
+	}
+
+	_,err = NewConfigurationFromIniString( testdata_badsection2 )
+	if ! errors.Is( err , ErrInvalidKeyValue ) {
+		t.Errorf( "Error should match ErrInvalidKeyValue: %s" , err )
+	}
+	if _,err = NewConfigurationFromIniString( "" ) ; ! errors.Is( err , ErrEmptyInput ) {
+		t.Errorf( "Empty string should return ErrEmptyInput: %s" , err )
+	}
+}
+
+var testdata_lenient = `
+[good]
+a=1
+this line is bad
+b=2
+[broken
+c=3
+`
+
+func TestLenientParse( t *testing.T ){
+	options := DefaultParseOptions()
+	options.Lenient = true
+	config,err := NewConfigurationFromIniStringWithOptions( testdata_lenient , options )
+
+	if config == nil {
+		t.Fatalf( "Lenient parse should return a configuration" )
+	}
+	if err == nil {
+		t.Fatalf( "Lenient parse should return the errors found" )
+	}
+	checkSection( t , config , "good" , "a" , "1")
+	checkSection( t , config , "good" , "b" , "2")
+	if config.IsOption( "good" , "c" ) {
+		t.Errorf( "Options after a bad section line should be skipped" )
+	}
+
+	if ! errors.Is( err , ErrInvalidKeyValue ) || ! errors.Is( err , ErrInvalidSection ) {
+		t.Errorf( "Both errors should have been returned: %s" , err )
+	}
+	joined, ok := err.( interface{ Unwrap() []error } )
+	if ! ok || len( joined.Unwrap() ) != 2 {
+		t.Fatalf( "Expected 2 joined errors: %s" , err )
+	}
+	var perr *ParseError
+	if ! errors.As( joined.Unwrap()[1] , &perr ) || perr.Line != 6 {
+		t.Errorf( "Second error should be on line 6: %s" , joined.Unwrap()[1] )
+	}
+
+	if _,err = NewConfigurationFromIniString( testdata_lenient ) ; err == nil {
+		t.Errorf( "Strict parse should fail" )
+	}
+}
+
+var testdata_continue = `
+[multi]
+hosts = one, \
+        two, \
+        three
+path = C:\\
+after = 1
+`
+
+var testdata_indent = `
+[multi]
+sql = SELECT *
+    FROM table
+    # comment inside the value
+    WHERE a = 1
+
+after = 1
+  key = value
+`
+
+func TestBackslashContinuation( t *testing.T ){
+	options := DefaultParseOptions()
+	options.Continuation = ContinueBackslash
+	config,err := NewConfigurationFromIniStringWithOptions( testdata_continue , options )
+	if err != nil {
+		t.Fatalf( "Error: could not ini from string: %s" , err )
+	}
+	checkSection( t , config , "multi" , "hosts" , "one, two, three")
+	checkSection( t , config , "multi" , "path" , `C:\\`)
+	checkSection( t , config , "multi" , "after" , "1")
+
+	if _,err = NewConfigurationFromIniString( testdata_indent ) ; err == nil {
+		t.Errorf( "Indented lines should not join by default" )
+	}
+}
+
+func TestBackslashNotDefault( t *testing.T ){
+	config,err := NewConfigurationFromIniString( "[win]\ndir = C:\\temp\\\nnext = 1\n" )
+	if err != nil {
+		t.Fatalf( "Error: could not ini from string: %s" , err )
+	}
+	checkSection( t , config , "win" , "dir" , `C:\temp\`)
+	checkSection( t , config , "win" , "next" , "1")
+}
+
+func TestIndentContinuation( t *testing.T ){
+	options := DefaultParseOptions()
+	options.Continuation = ContinueIndent
+	config,err := NewConfigurationFromIniStringWithOptions( testdata_indent , options )
+
+	if err != nil {
+		t.Fatalf( "Error: could not ini from string: %s" , err )
+	}
+	checkSection( t , config , "multi" , "sql" , "SELECT *\nFROM table\nWHERE a = 1")
+	checkSection( t , config , "multi" , "after" , "1\nkey = value")
+
+	// Options indented the same as the one above are not continuations
+	config,err = NewConfigurationFromIniStringWithOptions( "[m]\n  key1 = v1\n  key2 = v2\n    more\n" , options )
+	if err != nil {
+		t.Fatalf( "Error: could not ini from string: %s" , err )
+	}
+	checkSection( t , config , "m" , "key1" , "v1")
+	checkSection( t , config , "m" , "key2" , "v2\nmore")
+
+	options.Continuation = ContinueNone
+	if _,err = NewConfigurationFromIniStringWithOptions( testdata_continue , options ) ; err == nil {
+		t.Errorf( "Backslash lines should not join with ContinueNone" )
+	}
+}
+
+var testdata_continue_error = `
+[ok]
+a = 1 \
+    2
+bad line \
+   still bad
+`
+
+func TestContinuationLineNumbers( t *testing.T ){
+	options := DefaultParseOptions()
+	options.Continuation = ContinueBackslash
+	_,err := NewConfigurationFromIniStringWithOptions( testdata_continue_error , options )
+	var perr *ParseError
+	if ! errors.As( err , &perr ) || perr.Line != 5 {
+		t.Errorf( "Error should be for line 5: %v" , err )
+	}
+}
+
+var testdata_inline = `
+[db]   ; the database
+host = db1 ; primary
+port = 5432 # default port
+url  = http://example.com/#anchor
+name = "db ; one" ; quoted
+pass = 'a#b'#raw
+cmd  = echo "a ; b" 'c # d' ; run it
+note = it's here ; the apostrophe is not a quote
+none = ; only a comment
+sql  = SELECT 1 ; first
+    FROM dual   ; second
+`
+
+func TestInlineComments( t *testing.T ){
+	options := DefaultParseOptions()
+	options.Continuation |= ContinueIndent
+	config,err := NewConfigurationFromIniStringWithOptions( testdata_inline , options )
+	if err != nil {
+		t.Fatalf( "Error: could not ini from string: %s" , err )
+	}
+	checkSection( t , config , "db" , "host" , "db1")
+	checkSection( t , config , "db" , "port" , "5432")
+	checkSection( t , config , "db" , "url"  , "http://example.com/#anchor")
+	checkSection( t , config , "db" , "name" , "db ; one")
+	checkSection( t , config , "db" , "pass" , "a#b")
+	checkSection( t , config , "db" , "cmd"  , `echo "a ; b" 'c # d'`)
+	checkSection( t , config , "db" , "note" , "it's here")
+	checkSection( t , config , "db" , "none" , "")
+	checkSection( t , config , "db" , "sql"  , "SELECT 1\nFROM dual")
+
+	options.CommentMarkers = []string{"//"}
+	config,err = NewConfigurationFromIniStringWithOptions( "[s]\na = 1 // one\nb = 2 ; two" , options )
+	if err != nil {
+		t.Fatalf( "Error: could not ini from string: %s" , err )
+	}
+	checkSection( t , config , "s" , "a" , "1")
+	checkSection( t , config , "s" , "b" , "2 ; two")
+
+	options.InlineComments = false
+	config,err = NewConfigurationFromIniStringWithOptions( "[s]\na = 1 ; one" , options )
+	if err != nil {
+		t.Fatalf( "Error: could not ini from string: %s" , err )
+	}
+	checkSection( t , config , "s" , "a" , "1 ; one")
+}
+
 func TestAddSection_And_GetSectionNames( t *testing.T ){
 	config := NewConfiguration()
 
@@ -249,6 +558,30 @@ func TestAddSection_And_GetSectionNames( t *testing.T ){
 	}
 }
 
+func TestSectionAndOptionOrder( t *testing.T ){
+	config,_ := NewConfigurationFromIniString( "[zeta]\nz=1\ny=2\nx=3\n[alpha]\nc=1\n[mid]\nb=1\n[zeta]\na=4\ny=5" )
+
+	for i := 0 ; i < 5 ; i++ {
+		if names := strings.Join( config.GetSectionNames() , "," ) ; names != "_default,zeta,alpha,mid" {
+			t.Fatalf( "Sections are not in file order: %s" , names )
+		}
+		if names := strings.Join( config.OptionNames( "zeta" ) , "," ) ; names != "z,y,x,a" {
+			t.Fatalf( "Options are not in file order: %s" , names )
+		}
+	}
+
+	config.DeleteSection( "alpha" )
+	config.DeleteOption( "zeta" , "y" )
+	config.SetString( "alpha" , "new" , "1" )
+	config.SetString( "zeta" , "y" , "6" )
+	if names := strings.Join( config.GetSectionNames() , "," ) ; names != "_default,zeta,mid,alpha" {
+		t.Errorf( "Sections are not in order after changes: %s" , names )
+	}
+	if names := strings.Join( config.OptionNames( "zeta" ) , "," ) ; names != "z,x,a,y" {
+		t.Errorf( "Options are not in order after changes: %s" , names )
+	}
+}
+
 func TestDeleteSection( t *testing.T ){
 	config := NewConfiguration()
 	config.AddSection("TEST")
@@ -0,0 +1,162 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Include directives pull other files into the configuration:
+//
+//   include conf/base.ini
+//   include_if_exists /etc/app/local.ini
+//   include conf.d/*.ini
+//
+// Relative names are found from the directory of the file doing the
+// including (or the current directory when parsing a string). The included
+// file starts in the current section and any sections it opens are closed
+// when it ends. Globs are read in name order and may match nothing.
+// A line with an '=' is always an option, so 'include path = /x' is still
+// an option named 'include path'.
+//
+// The cache only records the main file, so it isn't used for files that
+// have include directives.
+
+const (
+	directiveInclude         = "include"
+	directiveIncludeIfExists = "include_if_exists"
+
+	defaultMaxIncludeDepth = 10
+)
+
+// includeFrame records where an include directive was found
+type includeFrame struct {
+	file string // name of the file holding the directive
+	path string // absolute name of that file, for cycle checks
+	line int
+}
+
+// splitDirective returns the directive and its argument when the line
+// is an include directive. Lines with an '=' are options, not directives.
+func splitDirective(line string) (string, string, bool) {
+	i := strings.IndexAny(line, " \t")
+	if i < 0 || strings.Contains(line, "=") {
+		return "", "", false
+	}
+	directive, arg := line[:i], strings.TrimSpace(line[i:])
+	if directive != directiveInclude && directive != directiveIncludeIfExists {
+		return "", "", false
+	}
+	if arg == "" {
+		return "", "", false
+	}
+	return directive, arg, true
+}
+
+// location formats a file and line as 'file:line'
+func location(file string, line int) string {
+	if file == "" {
+		file = "<string>"
+	}
+	return file + ":" + strconv.Itoa(line)
+}
+
+// chain returns the include directives being processed, outermost first
+func (p *parser) chain() []string {
+	chain := make([]string, len(p.stack))
+	for i, frame := range p.stack {
+		chain[i] = location(frame.file, frame.line)
+	}
+	return chain
+}
+
+// isOpen returns true if the file is already being parsed
+func (p *parser) isOpen(path string) bool {
+	if p.path == path {
+		return true
+	}
+	for _, frame := range p.stack {
+		if frame.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// include handles an include directive found on the line.
+func (p *parser) include(l *logicalLine, directive, arg string) error {
+	p.includes = true
+	name := conformValue(p.stripComment(arg))
+	if !filepath.IsAbs(name) && p.file != "" {
+		name = filepath.Join(filepath.Dir(p.file), name)
+	}
+
+	if strings.ContainsAny(name, "*?[") {
+		p.config.globs = append(p.config.globs, name)
+		matches, err := filepath.Glob(name)
+		if err != nil {
+			return p.fail(l.line, l.indent+1, l.text, err)
+		}
+		for _, match := range matches {
+			if err := p.includeFile(l, match); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if directive == directiveIncludeIfExists {
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+	}
+	return p.includeFile(l, name)
+}
+
+// includeFile parses a single included file, checking for cycles and depth.
+func (p *parser) includeFile(l *logicalLine, name string) error {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return p.fail(l.line, l.indent+1, l.text, err)
+	}
+
+	if p.isOpen(path) {
+		files := append(p.chain(), location(p.file, l.line), name)
+		return p.fail(l.line, l.indent+1, l.text,
+			fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(files, " -> ")))
+	}
+
+	maxDepth := p.options.MaxIncludeDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxIncludeDepth
+	}
+	if len(p.stack) >= maxDepth {
+		return p.fail(l.line, l.indent+1, l.text,
+			fmt.Errorf("%w: more than %d levels", ErrIncludeDepth, maxDepth))
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return p.fail(l.line, l.indent+1, l.text, err)
+	}
+	defer file.Close()
+
+	// Save where we are and parse the new file
+	p.stack = append(p.stack, includeFrame{file: p.file, path: p.path, line: l.line})
+	savedFile, savedPath, savedSection, savedIgnore := p.file, p.path, p.section, p.ignore
+	p.file, p.path = name, path
+	p.config.sources = append(p.config.sources, name)
+
+	err = p.parse(file)
+
+	p.file, p.path, p.section, p.ignore = savedFile, savedPath, savedSection, savedIgnore
+	p.stack = p.stack[:len(p.stack)-1]
+	return err
+}
@@ -0,0 +1,129 @@
+package gofig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates each file (name => contents) in a new temp directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.ini": `
+[db]
+host = main
+include site.ini
+port = 1
+include_if_exists missing.ini
+include conf.d/*.ini
+`,
+		"site.ini":     "user = site\n[site]\nname = one\n",
+		"conf.d/a.ini": "[a]\nvalue = a\n",
+		"conf.d/b.ini": "[a]\nvalue = b\n",
+	})
+
+	config, err := NewConfigurationFromIniFile(filepath.Join(dir, "main.ini"))
+	if err != nil {
+		t.Fatalf("Include failed: %s", err)
+	}
+	checkSection(t, config, "db", "host", "main")
+	checkSection(t, config, "db", "user", "site")
+	checkSection(t, config, "db", "port", "1")
+	checkSection(t, config, "site", "name", "one")
+	checkSection(t, config, "a", "value", "b")
+
+	if sources := config.Sources(); len(sources) != 4 {
+		t.Errorf("Expected 4 sources but got %v", sources)
+	}
+}
+
+func TestInclude_Errors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"missing.ini": "include nothere.ini\n",
+		"a.ini":       "[a]\ninclude b.ini\n",
+		"b.ini":       "\n\ninclude a.ini\n",
+		"bad.ini":     "include b2.ini\n",
+		"b2.ini":      "[b]\nno value here\n",
+		"self.ini":    "include self.ini\n",
+	})
+
+	_, err := NewConfigurationFromIniFile(filepath.Join(dir, "missing.ini"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a not-found error but got %v", err)
+	}
+
+	_, err = NewConfigurationFromIniFile(filepath.Join(dir, "a.ini"))
+	var perr *ParseError
+	if !errors.Is(err, ErrIncludeCycle) || !errors.As(err, &perr) {
+		t.Fatalf("Expected an include cycle but got %v", err)
+	}
+	if perr.Line != 3 || len(perr.Includes) != 1 || !strings.HasSuffix(perr.Includes[0], "a.ini:2") {
+		t.Errorf("Wrong include chain: %s", err)
+	}
+
+	_, err = NewConfigurationFromIniFile(filepath.Join(dir, "bad.ini"))
+	if !errors.As(err, &perr) || perr.Line != 2 || filepath.Base(perr.File) != "b2.ini" {
+		t.Errorf("Error should be reported in b2.ini: %v", err)
+	}
+
+	options := DefaultParseOptions()
+	options.MaxIncludeDepth = 3
+	_, err = NewConfigurationFromIniFileWithOptions(filepath.Join(dir, "self.ini"), options)
+	if !errors.Is(err, ErrIncludeCycle) {
+		t.Errorf("Expected an include cycle but got %v", err)
+	}
+}
+
+func TestInclude_Depth(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"1.ini": "include 2.ini\n",
+		"2.ini": "include 3.ini\n",
+		"3.ini": "include 4.ini\n",
+		"4.ini": "[four]\na = 4\n",
+	})
+	options := DefaultParseOptions()
+	options.MaxIncludeDepth = 2
+	if _, err := NewConfigurationFromIniFileWithOptions(filepath.Join(dir, "1.ini"), options); !errors.Is(err, ErrIncludeDepth) {
+		t.Errorf("Expected a depth error but got %v", err)
+	}
+	options.MaxIncludeDepth = 3
+	if _, err := NewConfigurationFromIniFileWithOptions(filepath.Join(dir, "1.ini"), options); err != nil {
+		t.Errorf("3 levels should be allowed: %s", err)
+	}
+}
+
+func TestInclude_OptionsAndCache(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.ini": "include path = /x\ninclude site.ini\n",
+		"site.ini": "[site]\nname = one\n",
+	})
+	options := DefaultParseOptions()
+	options.Cache = filepath.Join(dir, "main.gob")
+	config, err := NewConfigurationFromIniFileWithOptions(filepath.Join(dir, "main.ini"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSection(t, config, "default", "include path", "/x")
+	checkSection(t, config, "site", "name", "one")
+
+	// An edit to the included file would not be seen through the cache
+	if _, err := os.Stat(options.Cache); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The cache should not be written when files are included: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A section can inherit options from other sections: [three : two : one].
+// The parents are only recorded, not copied, and are searched each time an
+// option is looked up. The parents are evaluated from left to right, so
+// 'one' takes priority over 'two', and the section's own options take
+// priority over both. This means a parent can be defined after its children
+// and any later changes to a parent will be seen by the children.
+
+// ErrInheritanceCycle is returned when a section would inherit from itself
+var ErrInheritanceCycle = errors.New("Inheritance cycle")
+
+// AddParent records that a section inherits from a parent section. The
+// parent has a higher priority than any parents added before it. The
+// section is created if it doesn't exist. If the
+// parent already inherits from the section, directly or not, an error
+// wrapping ErrInheritanceCycle is returned.
+func (config *Configuration) AddParent(sectionName, parentName string) error {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	sectionName = conformSectionName(sectionName)
+	parentName = conformSectionName(parentName)
+	if path := config.inheritPath(parentName, sectionName, make(map[string]bool)); path != nil {
+		return fmt.Errorf("%w: %s", ErrInheritanceCycle,
+			strings.Join(append([]string{sectionName}, path...), " -> "))
+	}
+	config.addSection(sectionName)
+	if config.Inheritance == nil {
+		config.Inheritance = make(map[string][]string)
+	}
+	for _, parent := range config.Inheritance[sectionName] {
+		if parent == parentName {
+			return nil
+		}
+	}
+	config.Inheritance[sectionName] = append(config.Inheritance[sectionName], parentName)
+	return nil
+}
+
+// inheritPath returns the sections from 'from' up to the ancestor 'to',
+// or nil if 'from' doesn't inherit from 'to'.
+func (config *Configuration) inheritPath(from, to string, visited map[string]bool) []string {
+	if from == to {
+		return []string{from}
+	}
+	if visited[from] {
+		return nil
+	}
+	visited[from] = true
+	for _, parent := range config.Inheritance[from] {
+		if path := config.inheritPath(parent, to, visited); path != nil {
+			return append([]string{from}, path...)
+		}
+	}
+	return nil
+}
+
+// Parents will return the sections a section inherits from directly,
+// in the order they were declared.
+func (config *Configuration) Parents(sectionName string) []string {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return append([]string(nil), config.Inheritance[conformSectionName(sectionName)]...)
+}
+
+// Ancestors will return all of the sections a section inherits from, in
+// the order they are searched for an option.
+func (config *Configuration) Ancestors(sectionName string) []string {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.ancestors(sectionName)
+}
+
+func (config *Configuration) ancestors(sectionName string) []string {
+	sectionName = conformSectionName(sectionName)
+	visited := map[string]bool{sectionName: true}
+	var ancestors []string
+	var walk func(string)
+	walk = func(name string) {
+		parents := config.Inheritance[name]
+		for i := len(parents) - 1; i >= 0; i-- {
+			if !visited[parents[i]] {
+				visited[parents[i]] = true
+				ancestors = append(ancestors, parents[i])
+				walk(parents[i])
+			}
+		}
+	}
+	walk(sectionName)
+	return ancestors
+}
+
+// Descendants will return all of the sections that inherit from a section,
+// directly or through another section, in the order they were defined.
+func (config *Configuration) Descendants(sectionName string) []string {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	sectionName = conformSectionName(sectionName)
+	var descendants []string
+	for _, name := range config.sectionNames() {
+		if name != sectionName && config.inheritPath(name, sectionName, make(map[string]bool)) != nil {
+			descendants = append(descendants, name)
+		}
+	}
+	return descendants
+}
+
+// findOption will search a section and then its parents for an option.
+// The visited map stops a loop if the sections inherit from each other.
+func (config *Configuration) findOption(sectionName, optionName string, visited map[string]bool) (string, bool) {
+	if value, found := config.ConfigMap[sectionName][optionName]; found {
+		return value, true
+	}
+	parents := config.Inheritance[sectionName]
+	if len(parents) == 0 {
+		return "", false
+	}
+	if visited == nil {
+		visited = make(map[string]bool)
+	}
+	visited[sectionName] = true
+	for i := len(parents) - 1; i >= 0; i-- {
+		if !visited[parents[i]] {
+			if value, found := config.findOption(parents[i], optionName, visited); found {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// mergeInto copies the options of a section, including those inherited,
+// into the options map. Lower priority sections are copied first.
+func (config *Configuration) mergeInto(options ConfigOption, sectionName string, visited map[string]bool) {
+	if visited[sectionName] {
+		return
+	}
+	visited[sectionName] = true
+	for _, parent := range config.Inheritance[sectionName] {
+		config.mergeInto(options, parent, visited)
+	}
+	for key, value := range config.ConfigMap[sectionName] {
+		options[key] = value
+	}
+	delete(visited, sectionName) // A parent may be reached twice by different paths
+}
+
+// GetMergedSection will return a new map holding all of the options in a
+// section, including the ones it inherits. It is the same as GetSection.
+// GetOwnSection will only return the options set in the section itself.
+func (config *Configuration) GetMergedSection(sectionName string) (ConfigOption, bool) {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	sectionName = conformSectionName(sectionName)
+	if !config.isSection(sectionName) {
+		return nil, false
+	}
+	options := make(ConfigOption)
+	config.mergeInto(options, sectionName, make(map[string]bool))
+	return options, true
+}
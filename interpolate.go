@@ -0,0 +1,272 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Values may refer to other options. The references are replaced when
+// the value is fetched with GetString (or any of the Get functions):
+//
+//   ${key}          key in the same section, or the 'default' section
+//   ${section:key}  key from another section
+//   %(key)s         python configparser style, same as ${key}
+//   ${env:NAME}     the environment variable NAME
+//   ${ref:-text}    any of the above, using 'text' if it isn't set
+//   $$ and %%       a literal '$' or '%'
+//
+// A plain ${NAME} that isn't an option will also be looked for in the
+// environment. An unset ${env:NAME} is replaced by "" unless strict
+// environment checking is on. A '$' or '%' that isn't followed by one of
+// these is left alone.
+
+// Interpolation controls when references to other options are replaced
+type Interpolation int
+
+const (
+	// InterpolateLazy replaces references each time a value is fetched
+	InterpolateLazy Interpolation = iota
+
+	// InterpolateEager replaces all references once the file is loaded.
+	// Any bad references are reported as load errors.
+	InterpolateEager
+
+	// InterpolateNone turns off replacement; values are used as written
+	InterpolateNone
+)
+
+// Errors returned when a reference can't be replaced
+var (
+	ErrInterpolationCycle = errors.New("Interpolation cycle")
+	ErrEnvNotSet          = errors.New("Environment variable not set")
+)
+
+const envPrefix = "env:"
+
+// InterpolationError is returned when a reference within a value can't
+// be replaced.
+type InterpolationError struct {
+	Section   string // Section of the value holding the reference
+	Option    string // Option holding the reference
+	Reference string // The reference as written: ${db:host}
+	Err       error  // Why it failed
+}
+
+// Error will format the error with the section, option and reference.
+func (e *InterpolationError) Error() string {
+	return "Cannot replace " + e.Reference + " in [" + e.Section + "] " + e.Option + ": " + e.Err.Error()
+}
+
+// Unwrap returns the reason the reference could not be replaced
+func (e *InterpolationError) Unwrap() error {
+	return e.Err
+}
+
+// SetInterpolation turns the replacement of references in values on
+// (the default) or off.
+func (config *Configuration) SetInterpolation(flag bool) *Configuration {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	if flag {
+		config.interpolation = InterpolateLazy
+	} else {
+		config.interpolation = InterpolateNone
+	}
+	return config
+}
+
+// SetEnvLookup replaces the function used to find environment variables,
+// which is os.LookupEnv by default. Pass nil to go back to os.LookupEnv.
+func (config *Configuration) SetEnvLookup(lookup func(string) (string, bool)) *Configuration {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	config.lookupEnv = lookup
+	return config
+}
+
+// SetStrictEnv will cause a reference to an unset environment variable,
+// without a default, to return an ErrEnvNotSet error rather than "".
+func (config *Configuration) SetStrictEnv(flag bool) *Configuration {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	config.strictEnv = flag
+	return config
+}
+
+// escapeReferences doubles each '$' and '%' so the value is used as it
+// is, rather than as holding references
+func escapeReferences(value string) string {
+	if !strings.ContainsAny(value, "$%") {
+		return value
+	}
+	return strings.NewReplacer("$", "$$", "%", "%%").Replace(value)
+}
+
+// getenv looks up an environment variable with the configured function
+func (config *Configuration) getenv(name string) (string, bool) {
+	if config.lookupEnv == nil {
+		return os.LookupEnv(name)
+	}
+	return config.lookupEnv(name)
+}
+
+// Interpolate will replace the references in every value right away.
+// Afterwards the values are used as they are, and interpolation is turned
+// off. All of the bad references are returned, joined together.
+//
+// As with GetString, an inherited value is expanded in the section that
+// inherits it. If that gives a different value than the parent's, the
+// value is added to the section so GetString returns the same as before.
+func (config *Configuration) Interpolate() error {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	var errs []error
+	values := make(map[string]ConfigOption)
+
+	for _, section := range config.sectionNames() {
+		if section == "_default" {
+			continue
+		}
+		values[section] = make(ConfigOption)
+		for _, option := range config.mergedNames(section) {
+			raw, _ := config.findOption(section, option, nil)
+			value, err := config.expand(section, option, raw, nil)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			values[section][option] = value
+		}
+	}
+	for section, options := range values {
+		for option, value := range options {
+			if _, own := config.ConfigMap[section][option]; own {
+				config.ConfigMap[section][option] = value
+			}
+		}
+	}
+	// Adding a value to one section can change what its children inherit,
+	// so keep going until nothing more is added
+	for added := true; added; {
+		added = false
+		for _, section := range config.sectionNames() {
+			for _, option := range orderedNames(nil, values[section]) {
+				value := values[section][option]
+				if inherited, _ := config.findOption(section, option, nil); inherited != value {
+					config.setString(section, option, Origin{Value: value, Kind: OriginInherited})
+					added = true
+				}
+			}
+		}
+	}
+	config.interpolation = InterpolateNone
+	return errors.Join(errs...)
+}
+
+// expand replaces all of the references in a value. The seen list holds
+// 'section:option' for each value being expanded, to stop cycles.
+func (config *Configuration) expand(section, option, value string, seen []string) (string, error) {
+	if config.interpolation == InterpolateNone || !strings.ContainsAny(value, "$%") {
+		return value, nil
+	}
+	seen = append(seen, section+":"+option)
+
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c != '$' && c != '%') || i+1 == len(value) {
+			out.WriteByte(c)
+			continue
+		}
+
+		var ref, text string
+		switch next := value[i+1]; {
+		case next == c:
+			out.WriteByte(c)
+			i++
+			continue
+		case c == '$' && next == '{':
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 {
+				out.WriteByte(c)
+				continue
+			}
+			ref, text = value[i+2:i+2+end], value[i:i+3+end]
+		case c == '%' && next == '(':
+			end := strings.Index(value[i+2:], ")s")
+			if end < 0 {
+				out.WriteByte(c)
+				continue
+			}
+			ref, text = value[i+2:i+2+end], value[i:i+4+end]
+		default:
+			out.WriteByte(c)
+			continue
+		}
+
+		resolved, err := config.resolve(section, ref, seen)
+		if err != nil {
+			return "", &InterpolationError{Section: section, Option: option, Reference: text, Err: err}
+		}
+		out.WriteString(resolved)
+		i += len(text) - 1
+	}
+	return out.String(), nil
+}
+
+// resolve finds the value a reference points to and expands it.
+func (config *Configuration) resolve(section, ref string, seen []string) (string, error) {
+	ref, defaultValue, hasDefault := strings.Cut(ref, ":-")
+	ref = strings.TrimSpace(ref)
+
+	if strings.HasPrefix(ref, envPrefix) {
+		name := strings.TrimSpace(ref[len(envPrefix):])
+		if value, found := config.getenv(name); found {
+			return value, nil
+		}
+		if hasDefault {
+			return defaultValue, nil
+		}
+		if config.strictEnv {
+			return "", fmt.Errorf("%w: '%s'", ErrEnvNotSet, name)
+		}
+		return "", nil
+	}
+
+	refSection, refOption := section, ref
+	explicit := false
+	if i := strings.IndexByte(ref, ':'); i >= 0 {
+		refSection, refOption = strings.TrimSpace(ref[:i]), ref[i+1:]
+		explicit = true
+	}
+	refOption = strings.TrimSpace(refOption)
+
+	value, err := config.lookup(refSection, refOption)
+	if err != nil && !explicit {
+		if global, globalErr := config.lookup("default", refOption); globalErr == nil {
+			refSection, value, err = "default", global, nil
+		} else if env, found := config.getenv(refOption); found {
+			return env, nil
+		}
+	}
+	if err != nil {
+		if hasDefault {
+			return defaultValue, nil
+		}
+		return "", err
+	}
+
+	key := refSection + ":" + refOption
+	for i, s := range seen {
+		if s == key {
+			return "", fmt.Errorf("%w: %s", ErrInterpolationCycle, strings.Join(append(seen[i:], key), " -> "))
+		}
+	}
+	return config.expand(refSection, refOption, value, seen)
+}
@@ -0,0 +1,214 @@
+package gofig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testdata_interpolate = `
+base = /opt/app
+[paths]
+logs  = ${base}/logs
+data  = %(base)s/data
+other = ${server:host}:${server:port}
+price = $$5 and 100%% or 50%
+plain = $HOME and ${unclosed
+[server]
+host = localhost
+port = 80
+url  = http://${host}:${port}/${paths:logs}
+[loop]
+a = ${b}
+b = ${c}
+c = ${a}
+missing = ${nothere}
+`
+
+func TestInterpolation(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_interpolate)
+	if err != nil {
+		t.Fatalf("Error: could not ini from string: %s", err)
+	}
+	checkSection(t, config, "paths", "logs", "/opt/app/logs")
+	checkSection(t, config, "paths", "data", "/opt/app/data")
+	checkSection(t, config, "paths", "other", "localhost:80")
+	checkSection(t, config, "paths", "price", "$5 and 100% or 50%")
+	checkSection(t, config, "paths", "plain", "$HOME and ${unclosed")
+	checkSection(t, config, "server", "url", "http://localhost:80//opt/app/logs")
+
+	if raw, _ := config.GetRawString("paths", "logs"); raw != "${base}/logs" {
+		t.Errorf("Raw value should not be replaced: %s", raw)
+	}
+
+	_, err = config.GetString("loop", "a")
+	if !errors.Is(err, ErrInterpolationCycle) {
+		t.Errorf("Expected a cycle error but got %v", err)
+	}
+	_, err = config.GetString("loop", "missing")
+	var ierr *InterpolationError
+	if !errors.As(err, &ierr) || ierr.Reference != "${nothere}" || !errors.Is(err, ErrOptionNotFound) {
+		t.Errorf("Expected a missing reference error but got %v", err)
+	}
+	if value := config.GetStringWithDefault("loop", "missing", "def"); value != "def" {
+		t.Errorf("Bad references should return the default, not %s", value)
+	}
+
+	config.SetInterpolation(false)
+	checkSection(t, config, "paths", "logs", "${base}/logs")
+}
+
+func TestInterpolation_Eager(t *testing.T) {
+	options := DefaultParseOptions()
+	options.Interpolation = InterpolateEager
+	if _, err := NewConfigurationFromIniStringWithOptions(testdata_interpolate, options); !errors.Is(err, ErrInterpolationCycle) {
+		t.Errorf("Eager interpolation should report the cycle: %v", err)
+	}
+
+	config, err := NewConfigurationFromIniStringWithOptions("a = 1\n[s]\nb = ${a}$$", options)
+	if err != nil {
+		t.Fatalf("Error: could not ini from string: %s", err)
+	}
+	if raw, _ := config.GetRawString("s", "b"); raw != "1$" {
+		t.Errorf("Value should have been replaced at load: %s", raw)
+	}
+	checkSection(t, config, "s", "b", "1$")
+}
+
+var testdata_env = `
+[app]
+host   = ${env:APP_HOST}
+port   = ${APP_PORT:-8080}
+user   = ${env:APP_USER:-nobody}
+unset  = [${env:APP_UNSET}]
+shadow = ${name}
+name   = option
+`
+
+func TestInterpolation_Env(t *testing.T) {
+	env := map[string]string{"APP_HOST": "db.example.com", "name": "env"}
+	options := DefaultParseOptions()
+	options.LookupEnv = func(name string) (string, bool) {
+		value, found := env[name]
+		return value, found
+	}
+	config, err := NewConfigurationFromIniStringWithOptions(testdata_env, options)
+	if err != nil {
+		t.Fatalf("Error: could not ini from string: %s", err)
+	}
+	checkSection(t, config, "app", "host", "db.example.com")
+	checkSection(t, config, "app", "port", "8080")
+	checkSection(t, config, "app", "user", "nobody")
+	checkSection(t, config, "app", "unset", "[]")
+	checkSection(t, config, "app", "shadow", "option")
+
+	env["APP_PORT"] = "9000"
+	checkSection(t, config, "app", "port", "9000")
+
+	config.SetStrictEnv(true)
+	if _, err = config.GetString("app", "unset"); !errors.Is(err, ErrEnvNotSet) {
+		t.Errorf("Strict mode should fail for unset variables: %v", err)
+	}
+	checkSection(t, config, "app", "user", "nobody")
+
+	options.StrictEnv = true
+	options.Interpolation = InterpolateEager
+	if _, err = NewConfigurationFromIniStringWithOptions(testdata_env, options); !errors.Is(err, ErrEnvNotSet) {
+		t.Errorf("Eager strict mode should fail to load: %v", err)
+	}
+}
+
+func TestInterpolation_Cache(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"bad.ini":  "a = ${nothere:-x}${env:UNSET}\n",
+		"good.ini": "[s]\nb = $$ ${env:HOME}\n",
+	})
+	past := time.Now().Add(-time.Hour) // So the cache is newer than the files
+	for _, name := range []string{"bad.ini", "good.ini"} {
+		if err := os.Chtimes(filepath.Join(dir, name), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options := DefaultParseOptions()
+	options.Interpolation = InterpolateEager
+	options.StrictEnv = true
+	options.LookupEnv = func(string) (string, bool) { return "", false }
+
+	// A load that fails is not cached
+	options.Cache = filepath.Join(dir, "bad.gob")
+	for i := 0; i < 2; i++ {
+		if _, err := NewConfigurationFromIniFileWithOptions(filepath.Join(dir, "bad.ini"), options); !errors.Is(err, ErrEnvNotSet) {
+			t.Errorf("Load %d should fail: %v", i+1, err)
+		}
+	}
+	if _, err := os.Stat(options.Cache); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The cache should not be written for a failed load: %v", err)
+	}
+
+	// A load from the cache uses the same options as a fresh parse
+	options.Cache = filepath.Join(dir, "good.gob")
+	options.Interpolation = InterpolateNone
+	for i := 0; i < 2; i++ {
+		config, err := NewConfigurationFromIniFileWithOptions(filepath.Join(dir, "good.ini"), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.IsCache != (i == 1) {
+			t.Errorf("Load %d: IsCache is %v", i+1, config.IsCache)
+		}
+		checkSection(t, config, "s", "b", "$$ ${env:HOME}")
+	}
+	options.Interpolation = InterpolateEager
+	options.LookupEnv = func(string) (string, bool) { return "/home", true }
+	config, err := NewConfigurationFromIniFileWithOptions(filepath.Join(dir, "good.ini"), options)
+	if err != nil || !config.IsCache {
+		t.Fatalf("Expected a load from the cache: %v", err)
+	}
+	checkSection(t, config, "s", "b", "$ /home")
+}
+
+func TestInterpolation_Lenient(t *testing.T) {
+	options := DefaultParseOptions()
+	options.Lenient = true
+	options.LookupEnv = func(name string) (string, bool) { return "fake", true }
+	config, err := NewConfigurationFromIniStringWithOptions("[s]\nhome = ${env:HOME}\n[bad\n", options)
+	if !errors.Is(err, ErrInvalidSection) {
+		t.Errorf("Expected the bad section, got %v", err)
+	}
+	checkSection(t, config, "s", "home", "fake")
+
+	options.Interpolation = InterpolateEager
+	options.StrictEnv = true
+	options.LookupEnv = func(string) (string, bool) { return "", false }
+	config, err = NewConfigurationFromIniStringWithOptions("[s]\nhome = ${env:HOME}\nok = 1$$\n[bad\n", options)
+	if !errors.Is(err, ErrInvalidSection) || !errors.Is(err, ErrEnvNotSet) {
+		t.Errorf("Expected the bad section and the unset variable, got %v", err)
+	}
+	if raw, _ := config.GetRawString("s", "ok"); raw != "1$" {
+		t.Errorf("Eager interpolation was not done: %q", raw)
+	}
+}
+
+func TestInterpolation_EagerInherited(t *testing.T) {
+	input := "[p]\nhost = P\nurl = ${host}/u\n[c : p]\nhost = C\n[g : c]\n[h : p]\n"
+	lazy, err := NewConfigurationFromIniString(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := DefaultParseOptions()
+	options.Interpolation = InterpolateEager
+	eager, err := NewConfigurationFromIniStringWithOptions(input, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, section := range []string{"p", "c", "g", "h"} {
+		want, _ := lazy.GetString(section, "url")
+		checkSection(t, eager, section, "url", want)
+	}
+	checkSection(t, eager, "c", "url", "C/u")
+	if len(eager.OptionNames("h")) != 0 {
+		t.Errorf("A section that sees the same value should not get a copy: %v", eager.OptionNames("h"))
+	}
+}
@@ -5,10 +5,9 @@
 package gofig
 
 import (
-	"errors"
+	"fmt"
 	"strings"
 	"strconv"
-	//"fmt"
 )
 
 
@@ -31,21 +30,46 @@ func conformOption( option string ) string {
 // DeleteOption will delete an option within a given section. If the section or
 // option doesn't exist, the request will be ignored
 func (config *Configuration) DeleteOption(sectionName, optionName string) *Configuration {
+	config.mu.Lock()
+	defer config.mu.Unlock()
 	mm, ok := config.ConfigMap[sectionName]
 	if ok {
 		delete(mm, optionName)
+		delete(config.origins[sectionName], optionName)
+		delete(config.comments[sectionName], optionName)
+		config.OptionOrder[sectionName] = removeName(config.OptionOrder[sectionName], optionName)
 	}
 	return config
 }
 
 
-// MergeOptions This will merge a source section into a target section. This is
-// normally only used with the cascade-style functions in a section: [A : B : C]
+// OptionNames will return the names of the options set in a section, in
+// the order they were first set. Inherited options are not included.
+func (config *Configuration) OptionNames(sectionName string) []string {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.optionNames(sectionName)
+}
+
+func (config *Configuration) optionNames(sectionName string) []string {
+	sectionName = conformSectionName(sectionName)
+	return orderedNames(config.OptionOrder[sectionName], config.ConfigMap[sectionName])
+}
+
+// MergeOptions This will merge a source section into a target section. The
+// options are copied, so later changes to the source will not be seen. The
+// cascade-style sections [A : B : C] don't copy, they inherit when looked up.
 func (config *Configuration) MergeOptions(targetSection, sourceSection string) *Configuration {
-	ts := config.AddSection(targetSection)
-	if ss, found := config.GetSection(sourceSection); found {
-		for key, value := range ss {
-			ts[key] = value
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	targetSection = conformSectionName(targetSection)
+	sourceSection = conformSectionName(sourceSection)
+	config.addSection(targetSection)
+	if config.isSection(sourceSection) {
+		for _, key := range config.optionNames(sourceSection) {
+			origin := config.history(sourceSection, key)[0]
+			origin.Kind = OriginInherited
+			config.setString(targetSection, key, origin)
 		}
 	}
 	return config
@@ -53,80 +77,210 @@ func (config *Configuration) MergeOptions(targetSection, sourceSection string) *
 }
 
 // SetString will insert an option and value into a section. If the section
-// doesn't exist, it will be created
+// doesn't exist, it will be created. The value follows the same quoting
+// rules as the ini file, so "a\tb" will have a tab in it.
 func (config *Configuration) SetString(sectionName, optionName, value string) {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	config.setString(sectionName, optionName, Origin{Value: conformValue(value)})
+}
+
+// setString stores origin.Value, as-is, and records where it came from.
+// The origin's Section is only filled in if it is blank.
+func (config *Configuration) setString(sectionName, optionName string, origin Origin) {
+	sectionName = conformSectionName(sectionName)
+	optionName = conformOption(optionName)
+	if origin.Section == "" {
+		origin.Section = sectionName
+	}
+	origin.Option = optionName
 
-	mm := config.AddSection(sectionName)
-	mm[conformOption(optionName)] = conformOption(value)
+	mm := config.addSection(sectionName)
+	if _, found := mm[optionName]; !found {
+		if config.OptionOrder == nil {
+			config.OptionOrder = make(map[string][]string)
+		}
+		config.OptionOrder[sectionName] = append(config.OptionOrder[sectionName], optionName)
+	}
+	mm[optionName] = origin.Value
+	config.record(sectionName, origin)
 }
 
-// IsOption return true if a section and option exists in the config
+// IsOption return true if a section and option exists in the config,
+// either set in the section or inherited from a parent section
 func (config *Configuration ) IsOption( sectionName, optionName  string ) bool {
-        mm , found := config.ConfigMap[sectionName]
-	if found {
-		_ , found = mm[optionName]
-	}
-        return found
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	_, err := config.lookup(sectionName, optionName)
+	return err == nil
 }
 
-// GetString will search a section for a specific option. If the option
-// or section doesn't exist, an error will be returned.
-func (config *Configuration) GetString(sectionName, optionName string) (string, error) {
+// lookup will find the raw value of an option, as it was set, searching
+// the parent sections if it isn't in the section itself. If the
+// option or section doesn't exist, an error wrapping ErrSectionNotFound or
+// ErrOptionNotFound will be returned.
+func (config *Configuration) lookup(sectionName, optionName string) (string, error) {
 
-	mm, ok := config.GetSection( sectionName )
-	if !ok {
-		return "", errors.New("Section '" + sectionName + "' not found")
+	sectionName = conformSectionName(sectionName)
+	if !config.isSection(sectionName) {
+		return "", fmt.Errorf("%w: '%s'", ErrSectionNotFound, sectionName)
 	}
-	value, ok := mm[optionName]
+	value, ok := config.findOption(sectionName, optionName, nil)
 	if !ok {
-		return "", errors.New("Option '" + optionName + "' not found")
+		return "", fmt.Errorf("%w: '%s' in section '%s'", ErrOptionNotFound, optionName, sectionName)
 	}
 
 	return value, nil
 }
 
+// GetRawString will return the value of an option without replacing
+// any references (${key}) within it.
+func (config *Configuration) GetRawString(sectionName, optionName string) (string, error) {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.lookup(sectionName, optionName)
+}
+
+// GetString will search a section for a specific option. If the option
+// or section doesn't exist, an error wrapping ErrSectionNotFound or
+// ErrOptionNotFound will be returned. References to other options are
+// replaced unless interpolation has been turned off.
+func (config *Configuration) GetString(sectionName, optionName string) (string, error) {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.getString(sectionName, optionName)
+}
+
+func (config *Configuration) getString(sectionName, optionName string) (string, error) {
+	value, err := config.lookup(sectionName, optionName)
+	if err != nil {
+		return "", err
+	}
+	return config.expand(sectionName, optionName, value, nil)
+}
+
 // GetStringWithDefault will search a section for a specific option. If the
 // section or option doesn't exist, a default value (passed into the routine)
-// will be returned instead.
+// will be returned instead. The default is also returned if the value has
+// a bad reference within it, but isn't recorded in '_default'.
 func (config *Configuration) GetStringWithDefault(sectionName, optionName, defaultValue string) ( string ) {
-	value, error := config.GetString(sectionName, optionName)
+	config.mu.RLock()
+	value, error := config.getString(sectionName, optionName)
+	config.mu.RUnlock()
+	if error == nil {
+		return value
+	}
+
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	return config.getStringWithDefault(sectionName, optionName, defaultValue)
+}
+
+// getStringWithDefault does the work of GetStringWithDefault, with the
+// configuration already locked for writing.
+func (config *Configuration) getStringWithDefault(sectionName, optionName, defaultValue string) ( string ) {
+	value, error := config.lookup(sectionName, optionName)
+	if error != nil {
+		config.useDefault(sectionName, optionName, defaultValue)
+		return defaultValue
+	}
+	value, error = config.expand(sectionName, optionName, value, nil)
 	if error != nil {
-		config.SetString("_default", sectionName, optionName)
-		if config.OnDefaultAddToSection {
-			config.SetString( sectionName , optionName , defaultValue)
-		}
 		return defaultValue
 	}
 	return value
 }
 
-// GetInt will return an int64 value of the number, convertered
+// useDefault records that the default value was used for an option. The
+// '_default' section holds, for each section, a comma separated list of
+// the options that were defaulted. The value is also put in the section
+// if OnDefaultAddToSection is set.
+func (config *Configuration) useDefault(sectionName, optionName, defaultValue string) {
+	config.recordDefault(sectionName, optionName)
+	if config.OnDefaultAddToSection {
+		config.setString( sectionName , optionName , Origin{Value: conformValue(defaultValue), Kind: OriginDefault})
+	}
+}
+
+// recordDefault adds the option to the list of defaulted options kept in
+// the '_default' section
+func (config *Configuration) recordDefault(sectionName, optionName string) {
+	sectionName = conformSectionName(sectionName)
+	defaulted := splitList(config.ConfigMap["_default"][sectionName])
+	if !containsName(defaulted, optionName) {
+		defaulted = append(defaulted, optionName)
+		config.setString("_default", sectionName, Origin{Value: strings.Join(defaulted, ", ")})
+	}
+}
+
+// DefaultedOptions will return, for each section, the options that
+// weren't set and had a default value used for them instead.
+func (config *Configuration) DefaultedOptions() map[string][]string {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	report := make(map[string][]string)
+	for sectionName, options := range config.ConfigMap["_default"] {
+		report[sectionName] = splitList(options)
+	}
+	return report
+}
+
+// containsName returns true if the name is in the list
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseInt converts a value to an int64, returning a *ConversionError on failure
+func parseInt(sectionName, optionName, value string) (int64, error) {
+	i, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		return 0, &ConversionError{Section: sectionName, Option: optionName, Value: value, Type: "int", Err: err}
+	}
+	return i, nil
+}
+
+// parseBool converts a value to a bool, returning a *ConversionError on failure
+func parseBool(sectionName, optionName, value string) (bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &ConversionError{Section: sectionName, Option: optionName, Value: value, Type: "bool", Err: err}
+	}
+	return b, nil
+}
+
+// GetInt will return an int64 value of the number, convertered. If the
+// value isn't a number, a *ConversionError is returned.
 func (config *Configuration) GetInt(sectionName , optionName string ) (int64 , error ){
 	mm , err := config.GetString( sectionName , optionName )
 	if err != nil {
 		return 0, err
 	}
-	return strconv.ParseInt( mm , 0 , 64 )
+	return parseInt( sectionName , optionName , mm )
 }
 
 // GetIntWithDefault will return an int64 or the default value if nothing is available
 func (config *Configuration) GetIntWithDefault( sectionName, optionName string , defaultValue int64) (int64 , error){
 	i := strconv.FormatInt( defaultValue , 10 )
 	mm  := config.GetStringWithDefault( sectionName , optionName , i )
-	return strconv.ParseInt( mm , 0 , 64 )
+	return parseInt( sectionName , optionName , mm )
 }
 
 
 
 // GetBool will return an boolean value of the string, converted
 // Boolean values must conform to the strconv.ParseBool values (1/0, true/false,etc)
-// The values 'yes' and 'no' do not work
+// The values 'yes' and 'no' do not work and return a *ConversionError
 func (config *Configuration) GetBool(sectionName , optionName string ) (bool , error ){
 	mm , err := config.GetString( sectionName , optionName )
 	if err != nil {
 		return false, err
 	}
-	return strconv.ParseBool( mm  )
+	return parseBool( sectionName , optionName , mm )
 }
 
 // GetBoolWithDefault will return an bool or the default value if nothing is available
@@ -135,5 +289,5 @@ func (config *Configuration) GetBoolWithDefault( sectionName, optionName string
 	if err != nil {
 		return defaultValue,nil
 	}
-	return strconv.ParseBool( mm  )
+	return parseBool( sectionName , optionName , mm )
 }
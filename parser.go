@@ -0,0 +1,333 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Continuation selects how a value can be split over several lines.
+// The styles can be combined: ContinueBackslash | ContinueIndent
+type Continuation int
+
+const (
+	// ContinueNone treats every line on its own
+	ContinueNone Continuation = 0
+
+	// ContinueBackslash joins a line ending in '\' with the next line.
+	// The '\' is removed, as is the leading space of the next line. It is
+	// off by default, as values such as 'dir = C:\temp\' end in a '\'.
+	ContinueBackslash Continuation = 1 << iota
+
+	// ContinueIndent joins lines indented further than the option above
+	// them to its value, using a newline, the same as python's
+	// configparser. Blank lines end the value.
+	ContinueIndent
+)
+
+// ParseOptions controls how an ini file is read. Start with the values
+// from DefaultParseOptions and change only what you need.
+type ParseOptions struct {
+	// Name of the GOB cache file to use ("" for no cache). The cache is
+	// not written for files with include directives.
+	Cache string
+
+	// When true, bad lines are skipped rather than stopping the parse.
+	// All of the errors are returned, joined, along with the configuration.
+	Lenient bool
+
+	// Which styles of multi-line values are allowed.
+	Continuation Continuation
+
+	// When true, text following one of the CommentMarkers is dropped from
+	// values and section lines: 'host = db1 ; primary'. The marker must
+	// come after a space and not be inside of quotes.
+	InlineComments bool
+
+	// What starts an inline comment. Full-line comments always use # and ;
+	CommentMarkers []string
+
+	// How deeply include directives may be nested. 0 uses the default (10)
+	MaxIncludeDepth int
+
+	// When references to other options (${key}) are replaced
+	Interpolation Interpolation
+
+	// Used to find ${env:NAME} references. nil uses os.LookupEnv
+	LookupEnv func(string) (string, bool)
+
+	// When true, an unset environment variable without a default is an error
+	StrictEnv bool
+
+	// When true, the comments, blank lines and layout of the file are kept
+	// so WriteTo will only change the lines for options that have changed.
+	// Lines from included files are not kept. It can't be used with
+	// InterpolateEager, which would replace the references in the document.
+	KeepDocument bool
+}
+
+// DefaultParseOptions returns the options used by NewConfigurationFromIniFile
+// and NewConfigurationFromIniString.
+func DefaultParseOptions() ParseOptions {
+	return ParseOptions{
+		Continuation:    ContinueNone,
+		InlineComments:  true,
+		CommentMarkers:  []string{";", "#"},
+		MaxIncludeDepth: defaultMaxIncludeDepth,
+	}
+}
+
+// parser holds the state while reading through an ini file
+type parser struct {
+	config   *Configuration
+	options  ParseOptions
+	file     string         // name of the file for errors
+	path     string         // absolute name of the file, for include cycles
+	section  string         // current section
+	ignore   bool           // options are skipped after a bad section line
+	errs     []error        // errors collected in lenient mode
+	stack    []includeFrame // include directives being processed
+	includes bool           // an include directive was found
+}
+
+func newParser(config *Configuration, file string, options ParseOptions) *parser {
+	p := &parser{
+		config:  config,
+		options: options,
+		file:    file,
+		section: "default",
+	}
+	if file != "" {
+		p.path, _ = filepath.Abs(file)
+		config.sources = append(config.sources, file)
+	}
+	return p
+}
+
+// fail will record the error when in lenient mode, otherwise it is returned
+// so the parse stops.
+func (p *parser) fail(lineNumber, column int, text string, reason error) error {
+	err := &ParseError{File: p.file, Line: lineNumber, Column: column, Text: text, Reason: reason}
+	if len(p.stack) > 0 {
+		err.Includes = p.chain()
+	}
+	if p.options.Lenient {
+		p.errs = append(p.errs, err)
+		return nil
+	}
+	return err
+}
+
+// logicalLine is one or more physical lines joined by continuation
+type logicalLine struct {
+	text   string   // joined text, with surrounding space trimmed
+	line   int      // line number where it started
+	indent int      // leading white space on the first line
+	more   bool     // the last physical line ended with a backslash
+	raw    []string // the physical lines, as read
+}
+
+// add appends the next piece of text. If backslash continuation is on and
+// the text ends with an odd number of '\', the marker is removed and
+// more lines are expected.
+func (l *logicalLine) add(text, separator string, backslash bool) {
+	l.more = false
+	if backslash {
+		count := len(text) - len(strings.TrimRight(text, "\\"))
+		if count%2 == 1 {
+			text = text[:len(text)-1]
+			l.more = true
+		}
+	}
+	l.text += separator + text
+}
+
+// isComment returns true for lines that begin with a comment marker
+func isComment(line string) bool {
+	return len(line) > 0 && (line[0] == '#' || line[0] == ';')
+}
+
+// stripComment removes an inline comment from the text. A comment starts
+// with a marker that follows white space or a closing quote, so in
+// 'key = ; note' the value is empty. Markers inside of quoted strings,
+// which start the text or follow white space, are skipped over.
+func (p *parser) stripComment(text string) string {
+	if !p.options.InlineComments {
+		return text
+	}
+	spaced := text != "" && (text[0] == ' ' || text[0] == '\t')
+	text = strings.TrimSpace(text)
+	boundary := true
+	for i := 0; i < len(text); i++ {
+		if boundary {
+			if n := quotedLength(text[i:]); n > 0 {
+				i += n - 1
+				continue // boundary stays true after the closing quote
+			}
+			if (i > 0 || spaced) && p.isMarker(text[i:]) {
+				return strings.TrimSpace(text[:i])
+			}
+		}
+		boundary = text[i] == ' ' || text[i] == '\t' || text[i] == '\n'
+	}
+	return text
+}
+
+// isMarker returns true if the text starts with an inline comment marker
+func (p *parser) isMarker(text string) bool {
+	for _, marker := range p.options.CommentMarkers {
+		if marker != "" && strings.HasPrefix(text, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOption returns true when the line looks like a key/value line
+func (l *logicalLine) isOption() bool {
+	return l.text != "" && l.text[0] != '[' && !isComment(l.text)
+}
+
+// startLine creates a new logical line from a physical line
+func (p *parser) startLine(raw string, lineNumber int) *logicalLine {
+	line := strings.TrimSpace(raw)
+	l := &logicalLine{line: lineNumber, indent: strings.Index(raw, line), raw: []string{raw}}
+	l.add(line, "", p.options.Continuation&ContinueBackslash != 0 && !isComment(line))
+	return l
+}
+
+// continues will add the physical line to the logical line when it is
+// a continuation. It returns false if the line starts something new.
+func (p *parser) continues(l *logicalLine, raw string) bool {
+	line := strings.TrimSpace(raw)
+	if l.more {
+		l.add(line, "", true)
+		l.raw = append(l.raw, raw)
+		return true
+	}
+	indent := len(raw) - len(strings.TrimLeft(raw, " \t"))
+	if p.options.Continuation&ContinueIndent != 0 && l.isOption() &&
+		line != "" && indent > l.indent {
+		if !isComment(line) { // Indented comments inside a value are dropped
+			l.add(line, "\n", false)
+		}
+		l.raw = append(l.raw, raw)
+		return true
+	}
+	return false
+}
+
+// parse reads every line from the reader, joining continuation lines
+// before they are handled.
+func (p *parser) parse(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	var pending *logicalLine
+
+	for scanner.Scan() {
+		lineNumber++
+		raw := scanner.Text()
+		if pending != nil {
+			if p.continues(pending, raw) {
+				continue
+			}
+			if err := p.handleLine(pending); err != nil {
+				return err
+			}
+		}
+		pending = p.startLine(raw, lineNumber)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if pending != nil {
+		return p.handleLine(pending)
+	}
+	return nil
+}
+
+// handleLine parses the line and, when keeping the document, records it
+func (p *parser) handleLine(l *logicalLine) error {
+	failed := len(p.errs)
+	if err := p.parseLine(l); err != nil {
+		return err
+	}
+	if doc := p.config.document; doc != nil {
+		if len(p.stack) == 0 {
+			doc.add(p, l, len(p.errs) > failed)
+		} else if strings.HasPrefix(l.text, "[") {
+			doc.included[p.section] = true
+		}
+	}
+	return nil
+}
+
+// parseLine handles a single logical line: comment, section or key/value
+func (p *parser) parseLine(l *logicalLine) error {
+	line := l.text
+	lenLine := len(line)
+
+	// Comments and blank lines are ignored
+	if lenLine == 0 || isComment(line) {
+		return nil
+	}
+
+	if line[0:1] == "[" {
+		line = p.stripComment(line)
+		lenLine = len(line)
+		if line[lenLine-1:] != "]" {
+			// In lenient mode the options that follow don't belong to
+			// the previous section, so they are skipped
+			p.ignore = true
+			return p.fail(l.line, l.indent+lenLine+1, line, ErrInvalidSection)
+		}
+		p.ignore = false
+		p.section = strings.TrimSpace(line[1 : lenLine-1])
+		// Find out if there are any subsections (inheritance)
+		for i, name := range strings.Split(p.section, ":") {
+			sectionName := strings.TrimSpace(name)
+			if i == 0 {
+				p.section = sectionName
+				p.config.AddSection(sectionName)
+			} else if err := p.config.AddParent(p.section, sectionName); err != nil {
+				if err = p.fail(l.line, l.indent+1, line, err); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if p.ignore {
+		return nil
+	}
+
+	if directive, arg, ok := splitDirective(line); ok {
+		return p.include(l, directive, arg)
+	}
+
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return p.fail(l.line, l.indent+1, line, ErrInvalidKeyValue)
+	}
+	value := parts[1]
+	if p.options.InlineComments && strings.Contains(value, "\n") &&
+		quotedLength(strings.TrimSpace(value)) < 0 {
+		// Each line of an indented value may have its own comment
+		lines := strings.Split(value, "\n")
+		for i := range lines {
+			lines[i] = p.stripComment(lines[i])
+		}
+		value = strings.Join(lines, "\n")
+	}
+	p.config.setString(p.section, parts[0], Origin{
+		Value:  conformValue(p.stripComment(value)),
+		Source: p.file,
+		Line:   l.line,
+	})
+	return nil
+}
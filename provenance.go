@@ -0,0 +1,131 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"fmt"
+)
+
+// OriginKind says how an option got its value
+type OriginKind int
+
+const (
+	// OriginSet is a value set in the section, from a file or SetString
+	OriginSet OriginKind = iota
+
+	// OriginInherited is a value that came from another section, either
+	// by inheritance or by MergeOptions
+	OriginInherited
+
+	// OriginDefault is a value added by GetStringWithDefault when
+	// SetAddOnDefault is on
+	OriginDefault
+)
+
+// String returns the name of the kind: "set", "inherited" or "default"
+func (kind OriginKind) String() string {
+	switch kind {
+	case OriginInherited:
+		return "inherited"
+	case OriginDefault:
+		return "default"
+	}
+	return "set"
+}
+
+// Origin records where one value of an option came from
+type Origin struct {
+	Section    string     // Section the value was set in
+	Option     string     // Name of the option
+	Value      string     // The value, as it was set
+	Source     string     // File the value was read from ("" if not from a file)
+	Line       int        // Line within the file (0 if not from a file)
+	Kind       OriginKind // How the value got there
+	Overridden bool       // True if another value takes priority over this one
+}
+
+// String formats the origin as '[section] option = value (file:line)'
+func (origin Origin) String() string {
+	out := fmt.Sprintf("[%s] %s = %s", origin.Section, origin.Option, quoteValue(origin.Value))
+	if origin.Line > 0 {
+		out += " (" + location(origin.Source, origin.Line) + ")"
+	}
+	if origin.Kind != OriginSet {
+		out += " " + origin.Kind.String()
+	}
+	if origin.Overridden {
+		out += " overridden"
+	}
+	return out
+}
+
+// record adds an origin to the history of an option in a section. Values
+// that aren't from a file replace the one before them if it isn't from a
+// file either, so programs that keep setting a value don't grow the history.
+func (config *Configuration) record(sectionName string, origin Origin) {
+	if config.origins == nil {
+		config.origins = make(map[string]map[string][]Origin)
+	}
+	options := config.origins[sectionName]
+	if options == nil {
+		options = make(map[string][]Origin)
+		config.origins[sectionName] = options
+	}
+	history := options[origin.Option]
+	if n := len(history); n > 0 && (history[n-1] == origin || history[n-1].Line == 0 && origin.Line == 0) {
+		history[n-1] = origin
+		return
+	}
+	options[origin.Option] = append(history, origin)
+}
+
+// history returns the origins of an option set in a single section, the
+// most recent first. Values that were put straight into ConfigMap, or
+// loaded from a cache, are given an origin without a source.
+func (config *Configuration) history(sectionName, optionName string) []Origin {
+	value, found := config.ConfigMap[sectionName][optionName]
+	if !found {
+		return nil
+	}
+	recorded := config.origins[sectionName][optionName]
+	if len(recorded) == 0 || recorded[len(recorded)-1].Value != value {
+		recorded = append(recorded, Origin{Section: sectionName, Option: optionName, Value: value})
+	}
+	history := make([]Origin, len(recorded))
+	for i, origin := range recorded {
+		history[len(recorded)-1-i] = origin
+	}
+	return history
+}
+
+// Explain will return every value that was set for an option, starting
+// with the one GetString would use, followed by the values it overrides:
+// earlier values in the same section and then the values in parent
+// sections, in the order they are searched. Values from parent sections
+// are marked as OriginInherited.
+func (config *Configuration) Explain(sectionName, optionName string) ([]Origin, error) {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	sectionName = conformSectionName(sectionName)
+	if _, err := config.lookup(sectionName, optionName); err != nil {
+		return nil, err
+	}
+	return config.explain(sectionName, optionName), nil
+}
+
+// explain does the work of Explain, for an option known to exist
+func (config *Configuration) explain(sectionName, optionName string) []Origin {
+	chain := config.history(sectionName, optionName)
+	for _, ancestor := range config.ancestors(sectionName) {
+		for _, origin := range config.history(ancestor, optionName) {
+			origin.Kind = OriginInherited
+			chain = append(chain, origin)
+		}
+	}
+	for i := range chain {
+		chain[i].Overridden = i > 0
+	}
+	return chain
+}
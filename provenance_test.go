@@ -0,0 +1,81 @@
+package gofig
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+var testdata_provenance = `
+[base]
+host = one
+port = 80
+[site : base]
+host = two
+host = three
+`
+
+func TestExplain(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.ini": testdata_provenance})
+	file := filepath.Join(dir, "main.ini")
+	config, err := NewConfigurationFromIniFile(file)
+	if err != nil {
+		t.Fatalf("Error: could not read ini: %s", err)
+	}
+
+	chain, err := config.Explain("site", "host")
+	if err != nil {
+		t.Fatalf("Explain failed: %s", err)
+	}
+	if len(chain) != 3 {
+		t.Fatalf("Expected 3 origins but got %v", chain)
+	}
+	expect := []Origin{
+		{Section: "site", Option: "host", Value: "three", Source: file, Line: 7, Kind: OriginSet},
+		{Section: "site", Option: "host", Value: "two", Source: file, Line: 6, Kind: OriginSet, Overridden: true},
+		{Section: "base", Option: "host", Value: "one", Source: file, Line: 3, Kind: OriginInherited, Overridden: true},
+	}
+	for i := range expect {
+		if chain[i] != expect[i] {
+			t.Errorf("Origin %d should be %s but is %s", i, expect[i], chain[i])
+		}
+	}
+
+	chain, _ = config.Explain("site", "port")
+	if len(chain) != 1 || chain[0].Kind != OriginInherited || chain[0].Section != "base" || chain[0].Line != 4 {
+		t.Errorf("Wrong origin for an inherited value: %v", chain)
+	}
+
+	config.SetString("site", "port", "90")
+	chain, _ = config.Explain("site", "port")
+	if len(chain) != 2 || chain[0].Source != "" || chain[0].Value != "90" || !chain[1].Overridden {
+		t.Errorf("Wrong origins after SetString: %v", chain)
+	}
+
+	// Only the latest value set by the program is kept
+	for i := 0; i < 1000; i++ {
+		config.SetString("site", "port", "90")
+	}
+	config.SetString("site", "port", "91")
+	chain, _ = config.Explain("site", "port")
+	if len(chain) != 2 || chain[0].Value != "91" || len(config.origins["site"]["port"]) != 1 {
+		t.Errorf("Repeated sets should not be kept: %v", chain)
+	}
+
+	config.SetAddOnDefault(true)
+	config.GetStringWithDefault("site", "user", "nobody")
+	chain, _ = config.Explain("site", "user")
+	if len(chain) != 1 || chain[0].Kind != OriginDefault {
+		t.Errorf("Value should be marked as a default: %v", chain)
+	}
+
+	config.MergeOptions("copy", "site")
+	chain, _ = config.Explain("copy", "host")
+	if len(chain) != 1 || chain[0].Kind != OriginInherited || chain[0].Section != "site" || chain[0].Line != 7 {
+		t.Errorf("Merged value should keep its origin: %v", chain)
+	}
+
+	if _, err = config.Explain("site", "nothere"); !errors.Is(err, ErrOptionNotFound) {
+		t.Errorf("Expected ErrOptionNotFound but got %v", err)
+	}
+}
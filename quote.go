@@ -0,0 +1,151 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Values may be written in one of three ways:
+//
+//   key = plain value       the text is used as-is, with the spaces trimmed
+//   key = 'raw value'       everything between the single quotes, no escapes
+//   key = "quoted\tvalue"   double quotes, with these escapes:
+//                             \n \t \r \" \' \\ and \uXXXX
+//
+// An unknown escape is kept as-is, so "C:\dir" is still C:\dir. If the
+// quotes don't cover the whole value (""value"") the old rule is used and
+// only the outer pair of quotes is removed.
+
+// quotedLength returns the length of the quoted string at the start of s,
+// including both quote marks. If s doesn't start with a quote, or it isn't
+// terminated, -1 is returned.
+func quotedLength(s string) int {
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		return -1
+	}
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// conformValue will trim a value and remove the quotes, following the
+// quoting rules above.
+func conformValue(value string) string {
+	value = strings.TrimSpace(value)
+	if n := quotedLength(value); n == len(value) {
+		if value[0] == '"' {
+			return unescapeValue(value[1 : n-1])
+		}
+		return value[1 : n-1]
+	}
+	return conformOption(value)
+}
+
+// unescapeValue converts the escape sequences in a double-quoted string
+func unescapeValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '\\' || i+1 == len(value) {
+			out.WriteByte(c)
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 'r':
+			out.WriteByte('\r')
+		case '"', '\'', '\\':
+			out.WriteByte(value[i])
+		case 'u':
+			if i+4 < len(value) {
+				if r, err := strconv.ParseUint(value[i+1:i+5], 16, 32); err == nil {
+					out.WriteRune(rune(r))
+					i += 4
+					break
+				}
+			}
+			out.WriteString("\\u")
+		default:
+			out.WriteByte('\\')
+			out.WriteByte(value[i])
+		}
+	}
+	return out.String()
+}
+
+// escapeValue is the reverse of unescapeValue. The result should be
+// placed within double quotes.
+func escapeValue(value string) string {
+	var out strings.Builder
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		switch {
+		case r == '\n':
+			out.WriteString("\\n")
+		case r == '\t':
+			out.WriteString("\\t")
+		case r == '\r':
+			out.WriteString("\\r")
+		case r == '"' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r <= 0xFFFF && !unicode.IsPrint(r) && size > 1 || r < ' ' || r == 0x7F:
+			hex := strconv.FormatInt(int64(r), 16)
+			out.WriteString("\\u" + strings.Repeat("0", 4-len(hex)) + hex)
+		default:
+			out.WriteString(value[i : i+size]) // Keeps invalid bytes as they are
+		}
+		i += size
+	}
+	return out.String()
+}
+
+// quoteValue will return the value in a form that will read back in as
+// the same value. Quotes are only added when they are needed.
+func quoteValue(value string) string {
+	if needsQuotes(value) {
+		return `"` + escapeValue(value) + `"`
+	}
+	return value
+}
+
+// needsQuotes returns true if the value can't be written as-is
+func needsQuotes(value string) bool {
+	if value == "" {
+		return false
+	}
+	if value != strings.TrimSpace(value) || value[0] == '"' || value[0] == '\'' ||
+		strings.HasSuffix(value, "\\") {
+		return true
+	}
+	for i, r := range value {
+		if !unicode.IsPrint(r) && r != utf8.RuneError {
+			return true
+		}
+		// This would be read back in as an inline comment
+		if (r == ';' || r == '#') && i > 0 && unicode.IsSpace(rune(value[i-1])) {
+			return true
+		}
+	}
+	return false
+}
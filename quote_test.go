@@ -0,0 +1,46 @@
+package gofig
+
+import (
+	"testing"
+)
+
+var testdata_quotes = `
+[quotes]
+plain   =   some value  
+escaped = "tab\there\nnew \"line\" \\ \u00e9"
+spaces  = "  padded  "
+raw     = 'C:\new\'
+doubled = ""value""
+unknown = "C:\dir"
+partial = "not closed
+`
+
+func TestQuotedValues(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_quotes)
+	if err != nil {
+		t.Fatalf("Error: could not ini from string: %s", err)
+	}
+	checkSection(t, config, "quotes", "plain", "some value")
+	checkSection(t, config, "quotes", "escaped", "tab\there\nnew \"line\" \\ \u00e9")
+	checkSection(t, config, "quotes", "spaces", "  padded  ")
+	checkSection(t, config, "quotes", "raw", `C:\new\`)
+	checkSection(t, config, "quotes", "doubled", `"value"`)
+	checkSection(t, config, "quotes", "unknown", `C:\dir`)
+	checkSection(t, config, "quotes", "partial", `"not closed`)
+}
+
+func TestQuoteValue_RoundTrip(t *testing.T) {
+	values := []string{
+		"", "simple", "  lead", "trail ", "two\nlines", `"quoted"`, `'single'`,
+		`back\`, "host ; comment", "a #b", "a;b", "tab\tand\x01control", "caf\u00e9", `a "middle" b`,
+	}
+	for _, value := range values {
+		quoted := quoteValue(value)
+		if back := conformValue(quoted); back != value {
+			t.Errorf("Value %q was written as %s and read back as %q", value, quoted, back)
+		}
+	}
+	if quoteValue("simple") != "simple" {
+		t.Errorf("Simple values should not be quoted")
+	}
+}
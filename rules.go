@@ -0,0 +1,327 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Rules are written as a comma separated list, both in schema files and
+// in validate tags:
+//
+//   int, required, min=1, max=65535
+//   string, oneof=ro rw, default=ro
+//   duration, min=1s
+//   string, pattern=^[a-z]+(,[a-z]+)*$
+//
+// The type is one of string, int, float, bool or duration. For strings
+// min and max are the length. The pattern takes the rest of the rule, so
+// it may hold commas, and must come last.
+
+// Type is the kind of value an option must hold
+type Type int
+
+const (
+	TypeString Type = iota
+	TypeInt
+	TypeFloat
+	TypeBool
+	TypeDuration
+)
+
+var typeNames = []string{"string", "int", "float", "bool", "duration"}
+
+// String returns the name of the type, as used in rules
+func (t Type) String() string {
+	if t >= 0 && int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return "Type(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Errors found when checking a value against its rule. They are wrapped
+// in a *ValidationError with the details.
+var (
+	ErrRequired   = errors.New("Required but not set")
+	ErrWrongType  = errors.New("Wrong type")
+	ErrOutOfRange = errors.New("Out of range")
+	ErrNoMatch    = errors.New("Does not match the pattern")
+	ErrNotOneOf   = errors.New("Not one of the allowed values")
+
+	// Returned when a rule can't be parsed
+	ErrInvalidRule = errors.New("Invalid rule")
+)
+
+// Rule describes what the value of an option must look like
+type Rule struct {
+	Type     Type
+	Required bool
+	Min      *float64 // Lowest value, or length for strings. Seconds for durations.
+	Max      *float64
+	Pattern  *regexp.Regexp
+	Enum     []string // The only values allowed, if not empty
+	Default  *string  // Used when the option isn't set, see ApplyDefaults
+}
+
+// Require will mark the option as one that must be set
+func (rule *Rule) Require() *Rule {
+	rule.Required = true
+	return rule
+}
+
+// AtLeast will set the lowest value allowed
+func (rule *Rule) AtLeast(min float64) *Rule {
+	rule.Min = &min
+	return rule
+}
+
+// AtMost will set the highest value allowed
+func (rule *Rule) AtMost(max float64) *Rule {
+	rule.Max = &max
+	return rule
+}
+
+// Range will set the lowest and highest values allowed
+func (rule *Rule) Range(min, max float64) *Rule {
+	return rule.AtLeast(min).AtMost(max)
+}
+
+// Match will set a regular expression the value must match. It panics
+// if the pattern doesn't compile, as regexp.MustCompile does.
+func (rule *Rule) Match(pattern string) *Rule {
+	rule.Pattern = regexp.MustCompile(pattern)
+	return rule
+}
+
+// OneOf will set the only values that are allowed
+func (rule *Rule) OneOf(values ...string) *Rule {
+	rule.Enum = values
+	return rule
+}
+
+// WithDefault will set the value used when the option isn't set
+func (rule *Rule) WithDefault(value string) *Rule {
+	rule.Default = &value
+	return rule
+}
+
+// parseRule reads a rule written as a comma separated list into the rule.
+func parseRule(text string, rule *Rule) error {
+	var min, max string
+	for text = strings.TrimSpace(text); text != ""; {
+		item := text
+		if strings.HasPrefix(item, "pattern=") {
+			text = ""
+		} else if i := strings.IndexByte(text, ','); i >= 0 {
+			item, text = text[:i], strings.TrimSpace(text[i+1:])
+		} else {
+			text = ""
+		}
+		item = strings.TrimSpace(item)
+		key, value, _ := strings.Cut(item, "=")
+		value = strings.TrimSpace(value)
+
+		switch key = strings.TrimSpace(key); key {
+		case "":
+		case "required":
+			rule.Required = true
+		case "optional":
+			rule.Required = false
+		case "min":
+			min = value
+		case "max":
+			max = value
+		case "oneof":
+			rule.Enum = strings.Fields(value)
+		case "default":
+			rule.Default = &value
+		case "pattern":
+			pattern, err := regexp.Compile(value)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+			}
+			rule.Pattern = pattern
+		default:
+			t, found := parseType(item)
+			if !found {
+				return fmt.Errorf("%w: unknown '%s'", ErrInvalidRule, item)
+			}
+			rule.Type = t
+		}
+	}
+
+	// The bounds depend on the type, which may come after them
+	var err error
+	if rule.Min, err = parseBound(min, rule.Type, rule.Min); err != nil {
+		return err
+	}
+	rule.Max, err = parseBound(max, rule.Type, rule.Max)
+	return err
+}
+
+// parseType returns the type with the name given
+func parseType(name string) (Type, bool) {
+	for i, typeName := range typeNames {
+		if name == typeName {
+			return Type(i), true
+		}
+	}
+	return TypeString, false
+}
+
+// parseBound converts a min or max. Durations may be written as '30s'.
+func parseBound(text string, t Type, old *float64) (*float64, error) {
+	if text == "" {
+		return old, nil
+	}
+	if t == TypeDuration {
+		if d, err := time.ParseDuration(text); err == nil {
+			seconds := d.Seconds()
+			return &seconds, nil
+		}
+	}
+	bound, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: '%s' is not a number", ErrInvalidRule, text)
+	}
+	return &bound, nil
+}
+
+// formatBound writes a min or max in the form it would be read
+func formatBound(bound float64, t Type) string {
+	if t == TypeDuration {
+		return time.Duration(bound * float64(time.Second)).String()
+	}
+	return strconv.FormatFloat(bound, 'g', -1, 64)
+}
+
+// measure converts a value to the number that min and max are checked
+// against, returning an ErrWrongType error if it isn't of the right type.
+func (rule *Rule) measure(value string) (float64, error) {
+	var err error
+	n := 0.0
+	switch rule.Type {
+	case TypeString:
+		n = float64(utf8.RuneCountInString(value))
+	case TypeInt:
+		var i int64
+		i, err = strconv.ParseInt(value, 0, 64)
+		n = float64(i)
+	case TypeFloat:
+		n, err = strconv.ParseFloat(value, 64)
+	case TypeBool:
+		_, err = strconv.ParseBool(value)
+	case TypeDuration:
+		var d time.Duration
+		d, err = time.ParseDuration(value)
+		n = d.Seconds()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: '%s' is not a %s", ErrWrongType, value, rule.Type)
+	}
+	return n, nil
+}
+
+// check returns everything that is wrong with a value
+func (rule *Rule) check(value string) []error {
+	n, err := rule.measure(value)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	units := ""
+	if rule.Type == TypeString {
+		units = " characters long"
+	}
+	if rule.Min != nil && n < *rule.Min {
+		errs = append(errs, fmt.Errorf("%w: must be at least %s%s", ErrOutOfRange, formatBound(*rule.Min, rule.Type), units))
+	}
+	if rule.Max != nil && n > *rule.Max {
+		errs = append(errs, fmt.Errorf("%w: must be at most %s%s", ErrOutOfRange, formatBound(*rule.Max, rule.Type), units))
+	}
+	if rule.Pattern != nil && !rule.Pattern.MatchString(value) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrNoMatch, rule.Pattern))
+	}
+	if len(rule.Enum) > 0 && !containsName(rule.Enum, value) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrNotOneOf, strings.Join(rule.Enum, ", ")))
+	}
+	return errs
+}
+
+// checkCount checks the number of items in a list against min and max
+func (rule *Rule) checkCount(n int) []error {
+	var errs []error
+	if rule.Min != nil && float64(n) < *rule.Min {
+		errs = append(errs, fmt.Errorf("%w: must have at least %s items", ErrOutOfRange, formatBound(*rule.Min, TypeInt)))
+	}
+	if rule.Max != nil && float64(n) > *rule.Max {
+		errs = append(errs, fmt.Errorf("%w: must have at most %s items", ErrOutOfRange, formatBound(*rule.Max, TypeInt)))
+	}
+	return errs
+}
+
+// ValidationError describes a single value, or missing value, that
+// breaks a rule. Source and Line say where the value was set.
+type ValidationError struct {
+	Section string
+	Option  string // "" when a required section is missing
+	Value   string
+	Field   string // The struct field, when checked by a validate tag
+	Source  string
+	Line    int
+	Err     error // ErrRequired, ErrOutOfRange, ...
+}
+
+// Error will format the error as 'file:line: [section] option = 'value': reason'
+func (e *ValidationError) Error() string {
+	msg := "[" + e.Section + "]"
+	if e.Line > 0 {
+		msg = location(e.Source, e.Line) + ": " + msg
+	} else if e.Source != "" {
+		msg = e.Source + ": " + msg
+	}
+	if e.Option != "" {
+		msg += " " + e.Option
+	}
+	if !errors.Is(e.Err, ErrRequired) {
+		msg += " = '" + e.Value + "'"
+	}
+	if e.Field != "" {
+		msg += " (field " + e.Field + ")"
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+// Unwrap returns the reason, so errors.Is can match ErrOutOfRange, ...
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors is every violation found, in the order they were found
+type ValidationErrors []*ValidationError
+
+// Error will list every violation, one per line
+func (errs ValidationErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns the violations so errors.Is and errors.As can see them
+func (errs ValidationErrors) Unwrap() []error {
+	list := make([]error, len(errs))
+	for i, err := range errs {
+		list[i] = err
+	}
+	return list
+}
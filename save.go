@@ -0,0 +1,97 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// SaveIniFile will write the configuration to an ini file. The file is
+// written to a temporary file in the same directory, synced to disk and
+// then renamed over the original, so readers will see either the old or
+// the new file and never a partial one. The original file's permissions
+// and, where allowed, its owner are kept.
+func (config *Configuration) SaveIniFile(filename string) error {
+	return config.SaveIniFileWithBackup(filename, "")
+}
+
+// SaveIniFileWithBackup is SaveIniFile, but if backup isn't "" the
+// original file is first copied to the backup file.
+func (config *Configuration) SaveIniFileWithBackup(filename, backup string) error {
+	var data bytes.Buffer
+	if _, err := config.WriteTo(&data); err != nil {
+		return err
+	}
+
+	// Write through a symbolic link rather than replacing it
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+	}
+	info, statErr := os.Stat(filename)
+	if statErr != nil && !errors.Is(statErr, os.ErrNotExist) {
+		return statErr
+	}
+
+	dir := filepath.Dir(filename)
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempName := temp.Name()
+	defer os.Remove(tempName) // Fails once it has been renamed
+
+	mode := os.FileMode(0644)
+	if statErr == nil {
+		mode = info.Mode().Perm()
+	}
+	if _, err = temp.Write(data.Bytes()); err == nil {
+		if err = temp.Chmod(mode); err == nil {
+			err = temp.Sync()
+		}
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if statErr == nil {
+		if err = keepOwner(tempName, info); err != nil {
+			return err
+		}
+		if backup != "" {
+			if err = copyFile(filename, backup, mode); err != nil {
+				return err
+			}
+		}
+	}
+	if err = os.Rename(tempName, filename); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// copyFile copies the contents of a file to a new file, synced to disk
+func copyFile(from, to string, mode os.FileMode) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,21 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package gofig
+
+import (
+	"os"
+)
+
+// keepOwner does nothing on systems without unix-style owners
+func keepOwner(filename string, original os.FileInfo) error {
+	return nil
+}
+
+// syncDir does nothing on systems that can't sync a directory
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,51 @@
+package gofig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveIniFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"app.ini": "[db]\nhost = old\n"})
+	filename := filepath.Join(dir, "app.ini")
+	if err := os.Chmod(filename, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewConfigurationFromIniFile(filename)
+	if err != nil {
+		t.Fatalf("Error: could not read ini: %s", err)
+	}
+	config.SetString("db", "host", "new")
+	if err = config.SaveIniFileWithBackup(filename, filename+".bak"); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+
+	if data, _ := os.ReadFile(filename); string(data) != "[db]\nhost = new\n" {
+		t.Errorf("Wrong contents saved: %s", data)
+	}
+	if data, _ := os.ReadFile(filename + ".bak"); string(data) != "[db]\nhost = old\n" {
+		t.Errorf("Wrong contents in backup: %s", data)
+	}
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("File mode was not kept: %v", info.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Temporary files were left behind: %v", entries)
+	}
+
+	newFile := filepath.Join(dir, "new.ini")
+	if err = config.SaveIniFile(newFile); err != nil {
+		t.Fatalf("Save of a new file failed: %s", err)
+	}
+	again, err := NewConfigurationFromIniFile(newFile)
+	if err != nil {
+		t.Fatalf("Could not read the saved file: %s", err)
+	}
+	checkSection(t, again, "db", "host", "new")
+
+	if err = config.SaveIniFile(filepath.Join(dir, "missing", "x.ini")); err == nil {
+		t.Errorf("Save into a missing directory should fail")
+	}
+}
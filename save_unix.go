@@ -0,0 +1,37 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package gofig
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// keepOwner gives the file the same owner and group as the original. If we
+// aren't allowed to, the file is left owned by the current user.
+func keepOwner(filename string, original os.FileInfo) error {
+	stat, ok := original.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	err := os.Chown(filename, int(stat.Uid), int(stat.Gid))
+	if errors.Is(err, os.ErrPermission) {
+		return nil
+	}
+	return err
+}
+
+// syncDir flushes the directory so a rename within it is on disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
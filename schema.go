@@ -0,0 +1,263 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A schema describes the sections and options a configuration should
+// have. It can be built in code:
+//
+//   schema := NewSchema()
+//   db := schema.Section("db").Require()
+//   db.Option("host", TypeString).Require()
+//   db.Option("port", TypeInt).Require().Range(1, 65535)
+//   db.Option("mode", TypeString).OneOf("ro", "rw").WithDefault("ro")
+//
+// or read from an ini file, where each option holds its rule and the
+// '_section' option says if the section is required:
+//
+//   [db]
+//   _section = required
+//   host = string, required
+//   port = int, required, min=1, max=65535
+//   mode = string, oneof=ro rw, default=ro
+//
+// Options that aren't in the schema are allowed.
+
+// sectionOption is the option in a schema file that describes the section
+const sectionOption = "_section"
+
+// Schema holds the rules for each section
+type Schema struct {
+	sections map[string]*SectionSchema
+	order    []string
+}
+
+// SectionSchema holds the rules for the options of a single section
+type SectionSchema struct {
+	Name     string
+	Required bool
+	rules    map[string]*Rule
+	order    []string
+}
+
+// NewSchema will return an empty schema
+func NewSchema() *Schema {
+	return &Schema{sections: make(map[string]*SectionSchema)}
+}
+
+// Section will return the rules for a section, adding it if it isn't in
+// the schema yet
+func (schema *Schema) Section(sectionName string) *SectionSchema {
+	sectionName = conformSectionName(sectionName)
+	section, found := schema.sections[sectionName]
+	if !found {
+		section = &SectionSchema{Name: sectionName, rules: make(map[string]*Rule)}
+		schema.sections[sectionName] = section
+		schema.order = append(schema.order, sectionName)
+	}
+	return section
+}
+
+// SectionNames will return the sections in the schema, in the order
+// they were added
+func (schema *Schema) SectionNames() []string {
+	return append([]string(nil), schema.order...)
+}
+
+// Require will mark the section as one that must exist
+func (section *SectionSchema) Require() *SectionSchema {
+	section.Required = true
+	return section
+}
+
+// Option will return the rule for an option, adding it if needed, and
+// set the type of value it must hold.
+func (section *SectionSchema) Option(optionName string, t Type) *Rule {
+	optionName = conformOption(optionName)
+	rule, found := section.rules[optionName]
+	if !found {
+		rule = &Rule{}
+		section.rules[optionName] = rule
+		section.order = append(section.order, optionName)
+	}
+	rule.Type = t
+	return rule
+}
+
+// Rule will return the rule for an option, or nil if it has none
+func (section *SectionSchema) Rule(optionName string) *Rule {
+	return section.rules[conformOption(optionName)]
+}
+
+// OptionNames will return the options with rules, in the order they
+// were added
+func (section *SectionSchema) OptionNames() []string {
+	return append([]string(nil), section.order...)
+}
+
+// NewSchemaFromIniString will read a schema written in the ini format
+func NewSchemaFromIniString(input string) (*Schema, error) {
+	options := DefaultParseOptions()
+	options.Interpolation = InterpolateNone // Patterns may hold '$'
+	config, err := NewConfigurationFromIniStringWithOptions(input, options)
+	if err != nil {
+		return nil, err
+	}
+	return schemaFromConfiguration(config)
+}
+
+// NewSchemaFromIniFile will read a schema from an ini-style file. Errors
+// in the rules are reported with the file and line they are on.
+func NewSchemaFromIniFile(filename string) (*Schema, error) {
+	options := DefaultParseOptions()
+	options.Interpolation = InterpolateNone
+	config, err := NewConfigurationFromIniFileWithOptions(filename, options)
+	if err != nil {
+		return nil, err
+	}
+	return schemaFromConfiguration(config)
+}
+
+// schemaFromConfiguration builds a schema from the rules in a configuration
+func schemaFromConfiguration(config *Configuration) (*Schema, error) {
+	schema := NewSchema()
+	var errs []error
+	for _, sectionName := range config.GetSectionNames() {
+		if sectionName == "_default" {
+			continue
+		}
+		section := schema.Section(sectionName)
+		for _, optionName := range config.OptionNames(sectionName) {
+			text, _ := config.GetRawString(sectionName, optionName)
+			var err error
+			if optionName == sectionOption {
+				err = parseSectionRule(text, section)
+			} else {
+				err = parseRule(text, section.Option(optionName, TypeString))
+			}
+			if err != nil {
+				where := ""
+				if history := config.history(sectionName, optionName); len(history) > 0 && history[0].Line > 0 {
+					where = location(history[0].Source, history[0].Line) + ": "
+				}
+				errs = append(errs, fmt.Errorf("%s[%s] %s: %w", where, sectionName, optionName, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return schema, nil
+}
+
+// parseSectionRule reads the '_section' option of a schema file
+func parseSectionRule(text string, section *SectionSchema) error {
+	switch conformValue(text) {
+	case "required":
+		section.Required = true
+	case "optional", "":
+		section.Required = false
+	default:
+		return fmt.Errorf("%w: '%s' should be required or optional", ErrInvalidRule, text)
+	}
+	return nil
+}
+
+// Validate will check the configuration against the schema and return
+// every value that breaks a rule, as ValidationErrors, or nil if there
+// are none. Each error says where the value was set. Options missing
+// from a section that isn't there, and isn't required, are not checked,
+// nor are missing options with a default.
+func (config *Configuration) Validate(schema *Schema) error {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+
+	var errs ValidationErrors
+	for _, sectionName := range schema.order {
+		section := schema.sections[sectionName]
+		if !config.isSection(sectionName) && sectionName != "default" {
+			if section.Required {
+				errs = append(errs, &ValidationError{Section: sectionName, Err: ErrRequired, Source: config.source()})
+			}
+			continue
+		}
+		for _, optionName := range section.order {
+			errs = append(errs, config.validateOption(sectionName, optionName, section.rules[optionName])...)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateOption checks a single option against its rule
+func (config *Configuration) validateOption(sectionName, optionName string, rule *Rule) ValidationErrors {
+	raw, err := config.lookup(sectionName, optionName)
+	if err != nil {
+		if rule.Required && rule.Default == nil {
+			return ValidationErrors{{Section: sectionName, Option: optionName, Err: ErrRequired, Source: config.source()}}
+		}
+		return nil
+	}
+
+	origin := config.explain(sectionName, optionName)[0]
+	value, err := config.expand(sectionName, optionName, raw, nil)
+	failed := []error{err}
+	if err == nil {
+		failed = rule.check(value)
+	} else {
+		value = raw
+	}
+
+	var errs ValidationErrors
+	for _, err := range failed {
+		errs = append(errs, &ValidationError{Section: sectionName, Option: optionName, Value: value,
+			Source: origin.Source, Line: origin.Line, Err: err})
+	}
+	return errs
+}
+
+// source returns the main file the configuration was read from, if any
+func (config *Configuration) source() string {
+	if len(config.sources) > 0 {
+		return config.sources[0]
+	}
+	return ""
+}
+
+// ApplyDefaults will set every option that has a default in the schema,
+// and isn't set, to its default. The options are recorded in '_default'
+// just as GetStringWithDefault records them. Sections that don't exist
+// are only added if they are required.
+func (config *Configuration) ApplyDefaults(schema *Schema) *Configuration {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	for _, sectionName := range schema.order {
+		section := schema.sections[sectionName]
+		if !config.isSection(sectionName) && sectionName != "default" && !section.Required {
+			continue
+		}
+		for _, optionName := range section.order {
+			rule := section.rules[optionName]
+			if _, err := config.lookup(sectionName, optionName); err == nil || rule.Default == nil {
+				continue
+			}
+			config.recordDefault(sectionName, optionName)
+			config.setString(sectionName, optionName, Origin{Value: *rule.Default, Kind: OriginDefault})
+		}
+	}
+	return config
+}
+
+// Validate will check the snapshot against the schema, see
+// Configuration.Validate
+func (snap *Snapshot) Validate(schema *Schema) error {
+	return snap.config.Validate(schema)
+}
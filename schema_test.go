@@ -0,0 +1,159 @@
+package gofig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testdata_schema = `
+[db]
+_section = required
+host = string, required
+port = int, required, min=1, max=65535
+mode = string, oneof=ro rw, default=ro
+user = string, min=2, pattern=^[a-z]+(,[a-z]+)*$
+timeout = duration, min=1s, max=1m
+
+[cache]
+size = int, min=1
+
+[metrics]
+_section = required
+`
+
+func TestSchemaFromIni(t *testing.T) {
+	schema, err := NewSchemaFromIniString(testdata_schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	built := NewSchema()
+	db := built.Section("db").Require()
+	db.Option("host", TypeString).Require()
+	db.Option("port", TypeInt).Require().Range(1, 65535)
+	db.Option("mode", TypeString).OneOf("ro", "rw").WithDefault("ro")
+	db.Option("user", TypeString).AtLeast(2).Match("^[a-z]+(,[a-z]+)*$")
+	db.Option("timeout", TypeDuration).Range(1, 60)
+	built.Section("cache").Option("size", TypeInt).AtLeast(1)
+	built.Section("metrics").Require()
+
+	if !reflect.DeepEqual(schema, built) {
+		t.Errorf("Schema read as\n%#v\nbut built as\n%#v", schema.Section("db"), built.Section("db"))
+	}
+	if names := strings.Join(schema.SectionNames(), ","); names != "db,cache,metrics" {
+		t.Errorf("Schema sections are %s", names)
+	}
+}
+
+func TestSchemaErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"schema.ini": "[db]\nport = int, min=low\nmode = strnig\n_section = maybe\n"})
+	_, err := NewSchemaFromIniFile(filepath.Join(dir, "schema.ini"))
+	if !errors.Is(err, ErrInvalidRule) {
+		t.Fatalf("Expected ErrInvalidRule, got %v", err)
+	}
+	for _, expect := range []string{
+		"schema.ini:2: [db] port: Invalid rule: 'low' is not a number",
+		"schema.ini:3: [db] mode: Invalid rule: unknown 'strnig'",
+		"schema.ini:4: [db] _section: Invalid rule",
+	} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("Error should contain %q:\n%v", expect, err)
+		}
+	}
+	if _, err := NewSchemaFromIniString("x = string, pattern=(\n"); !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("A bad pattern should be an invalid rule, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema, err := NewSchemaFromIniString(testdata_schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeFiles(t, map[string]string{
+		"app.ini": "[base]\nport = 70000\n\n[db : base]\nmode = rw\nuser = Admin\ntimeout = 5m\n",
+	})
+	config, err := NewConfigurationFromIniFile(filepath.Join(dir, "app.ini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = config.Validate(schema)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+	file := filepath.Join(dir, "app.ini")
+	expect := []string{
+		file + ": [db] host: Required but not set",
+		file + ":2: [db] port = '70000': Out of range: must be at most 65535",
+		file + ":6: [db] user = 'Admin': Does not match the pattern: ^[a-z]+(,[a-z]+)*$",
+		file + ":7: [db] timeout = '5m': Out of range: must be at most 1m0s",
+		file + ": [metrics]: Required but not set",
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("Expected %d errors, got:\n%v", len(expect), err)
+	}
+	for i, e := range errs {
+		if e.Error() != expect[i] {
+			t.Errorf("Error %d was\n%s\nshould be\n%s", i, e.Error(), expect[i])
+		}
+	}
+	if !errors.Is(err, ErrOutOfRange) || !errors.Is(err, ErrRequired) {
+		t.Errorf("errors.Is should see the reasons")
+	}
+
+	// The value, not the rule, is wrong
+	config.SetString("db", "port", "many")
+	config.SetString("db", "host", "localhost")
+	config.SetString("db", "mode", "rx")
+	config.SetString("db", "user", "admin")
+	config.SetString("db", "timeout", "30s")
+	config.SetString("db", "size", "0") // Not in the schema
+	config.AddSection("metrics")
+	err = config.Validate(schema)
+	if !errors.Is(err, ErrWrongType) || !errors.Is(err, ErrNotOneOf) || errors.Is(err, ErrRequired) {
+		t.Errorf("Expected a wrong type and not one of, got %v", err)
+	}
+	config.SetString("db", "port", "5432")
+	config.SetString("db", "mode", "ro")
+	if err := config.Validate(schema); err != nil {
+		t.Errorf("Configuration should be valid: %v", err)
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	schema, err := NewSchemaFromIniString(testdata_schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfigurationFromIniString("[cache]\nsize = 2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema.Section("cache").Option("ttl", TypeDuration).WithDefault("10s")
+	config.ApplyDefaults(schema)
+
+	if mode, _ := config.GetString("db", "mode"); mode != "ro" {
+		t.Errorf("Required section db should have been added with mode 'ro', got %q", mode)
+	}
+	if ttl, _ := config.GetString("cache", "ttl"); ttl != "10s" {
+		t.Errorf("Default ttl was not set: %q", ttl)
+	}
+	expect := map[string][]string{"db": {"mode"}, "cache": {"ttl"}}
+	if report := config.DefaultedOptions(); !reflect.DeepEqual(report, expect) {
+		t.Errorf("Defaulted options were %v, should be %v", report, expect)
+	}
+	if history, _ := config.Explain("cache", "ttl"); history[0].Kind != OriginDefault {
+		t.Errorf("Default should be marked as OriginDefault: %v", history[0])
+	}
+}
+
+func TestSchemaFileMissing(t *testing.T) {
+	if _, err := NewSchemaFromIniFile(filepath.Join(t.TempDir(), "none.ini")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file error, got %v", err)
+	}
+}
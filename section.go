@@ -6,6 +6,7 @@
 package gofig
 
 import (
+	"sort"
 	"strings"
 	// "fmt"
 )
@@ -18,23 +19,56 @@ func conformSectionName( sectionName string ) string {
 }
 // IsSection will return true if a section name exists in the map
 func (config *Configuration ) IsSection( sectionName string ) bool {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.isSection( sectionName )
+}
+
+func (config *Configuration ) isSection( sectionName string ) bool {
 	_ , found := config.ConfigMap[sectionName]
 	return found
 }
+
 // GetSection will return a map for a given section and true  or return nil and false
+// The options inherited from parent sections are in the map, as they always
+// have been. GetOwnSection will only return the options set in the section.
+// The map is a copy, so changing it doesn't change the configuration.
 func (config *Configuration) GetSection(sectionName string) (ConfigOption, bool) {
+	return config.GetMergedSection(sectionName)
+}
+
+// GetOwnSection will return a copy of the options set in the section
+// itself, without those it inherits, and true or return nil and false
+func (config *Configuration) GetOwnSection(sectionName string) (ConfigOption, bool) {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
 	mm, found := config.ConfigMap[conformSectionName( sectionName )]
-	return mm, found
+	if !found {
+		return nil, false
+	}
+	copied := make(ConfigOption, len(mm))
+	for option, value := range mm {
+		copied[option] = value
+	}
+	return copied, true
+}
+
+// AddSection will create a section if it doesn't exist. Use SetString to
+// add options to it.
+func (config *Configuration) AddSection(sectionName string) *Configuration {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	config.addSection( sectionName )
+	return config
 }
 
-// AddSection will return a map for a given section. If the section
-// doesn't exist, it will be created
-func (config *Configuration) AddSection(sectionName string) ConfigOption {
+func (config *Configuration) addSection(sectionName string) ConfigOption {
 	sectionName  = conformSectionName( sectionName )
 	mm, ok := config.ConfigMap[sectionName]
 	if !ok {
 		mm = make(ConfigOption, 10)
 		config.ConfigMap[sectionName] = mm
+		config.SectionOrder = append(config.SectionOrder, sectionName)
 		config.Sections++
 	}
 	return mm
@@ -42,27 +76,65 @@ func (config *Configuration) AddSection(sectionName string) ConfigOption {
 
 // DeleteSection Delete all of the entries in a section. If the map doesn't exist, ignore it
 func (config *Configuration) DeleteSection( sectionName string ) *Configuration {
+	config.mu.Lock()
+	defer config.mu.Unlock()
 	sectionName  = conformSectionName( sectionName )
 	if _ , found := config.ConfigMap[sectionName] ; found {
 		for opt,_ := range config.ConfigMap[sectionName] {
 			delete( config.ConfigMap[sectionName] , opt )
 		}
 		delete( config.ConfigMap , sectionName)
+		delete( config.Inheritance , sectionName)
+		delete( config.origins , sectionName)
+		delete( config.comments , sectionName)
+		delete( config.OptionOrder , sectionName)
+		config.SectionOrder = removeName( config.SectionOrder , sectionName )
 		config.Sections--
 	}
 	return config
 }
 
-// GetSectionNames will return a complete list of all of the sections defined
+// GetSectionNames will return a complete list of all of the sections defined,
+// in the order they were first defined
 func (config *Configuration) GetSectionNames() []string {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.sectionNames()
+}
+
+func (config *Configuration) sectionNames() []string {
+	return orderedNames(config.SectionOrder, config.ConfigMap)
+}
 
-	names := make( []string , len(config.ConfigMap ) )
+// orderedNames returns the keys of the map, in the order given. Any keys
+// missing from the order (put straight into the map) follow, sorted.
+func orderedNames[V any](order []string, m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	seen := make(map[string]bool, len(m))
+	for _, name := range order {
+		if _, found := m[name]; found && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	if len(names) == len(m) {
+		return names
+	}
+	var extra []string
+	for name := range m {
+		if !seen[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	return append(names, extra...)
+}
 
-	i := 0
-	for name := range config.ConfigMap {
-		if name != "" {
-			names[i] = name
-			i++
+// removeName returns the list without the name in it
+func removeName(names []string, name string) []string {
+	for i, n := range names {
+		if n == name {
+			return append(names[:i:i], names[i+1:]...)
 		}
 	}
 	return names
@@ -0,0 +1,14 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package gofig
+
+import (
+	"os"
+)
+
+// reloadSignals is empty, as there is no SIGHUP here. Use Watcher.Reload.
+var reloadSignals []os.Signal
@@ -0,0 +1,16 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package gofig
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are the signals that, by convention, ask a daemon to load
+// its configuration again
+var reloadSignals = []os.Signal{syscall.SIGHUP}
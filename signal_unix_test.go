@@ -0,0 +1,69 @@
+//go:build unix
+
+package gofig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestSignalReloader(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.ini": "[pool]\nsize = 10\n"})
+	main := filepath.Join(dir, "main.ini")
+
+	var mu sync.Mutex
+	var failures []error
+	var sizes []string
+	w, err := NewSignalReloader(FileLoader(main, DefaultParseOptions()), func(err error) {
+		mu.Lock()
+		failures = append(failures, err)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	w.OnChange("pool", "size", func(old, new string) {
+		mu.Lock()
+		sizes = append(sizes, old+" -> "+new)
+		mu.Unlock()
+	})
+	locked := func(f func() bool) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return f()
+		}
+	}
+
+	if err := os.WriteFile(main, []byte("[pool]\nsize = 20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "reload on SIGHUP", locked(func() bool { return len(sizes) == 1 }))
+	if sizes[0] != "10 -> 20" {
+		t.Errorf("OnChange was called with %q", sizes[0])
+	}
+
+	// A bad file is reported and the old configuration kept
+	if err := os.WriteFile(main, []byte("[pool\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "reload error", locked(func() bool { return len(failures) == 1 }))
+	var perr *ParseError
+	if !errors.As(failures[0], &perr) {
+		t.Errorf("Expected a *ParseError, got %v", failures[0])
+	}
+	if size, _ := w.Snapshot().GetString("pool", "size"); size != "20" {
+		t.Errorf("A failed reload replaced the configuration: size is %q", size)
+	}
+}
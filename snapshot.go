@@ -0,0 +1,288 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Snapshot is a read-only copy of a configuration. It never changes once
+// it is made, so it can be shared between goroutines without any locking
+// and a reader always sees one consistent version of the configuration.
+type Snapshot struct {
+	config *Configuration // private copy; nothing may change it
+}
+
+// Snapshot will return a read-only copy of the configuration as it is now.
+// Later changes to the configuration are not seen by the snapshot, nor are
+// changes to the environment variables its values refer to.
+func (config *Configuration) Snapshot() *Snapshot {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	c := config.clone()
+	c.freezeEnv()
+	return &Snapshot{config: c}
+}
+
+// frozenEnv remembers each environment variable the first time it is
+// looked up, and gives the same answer from then on
+type frozenEnv struct {
+	mu     sync.Mutex
+	lookup func(string) (string, bool)
+	values map[string]*string // nil when the variable isn't set
+}
+
+func (env *frozenEnv) getenv(name string) (string, bool) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	value, found := env.values[name]
+	if !found {
+		if v, set := env.lookup(name); set {
+			value = &v
+		}
+		env.values[name] = value
+	}
+	if value == nil {
+		return "", false
+	}
+	return *value, true
+}
+
+// freezeEnv fixes the environment variables used by the configuration at
+// the values they have now. Every value is expanded once so the variables
+// are looked up while the snapshot is being made. The configuration must
+// not be shared yet.
+func (config *Configuration) freezeEnv() {
+	if config.interpolation != InterpolateLazy {
+		return
+	}
+	env := &frozenEnv{lookup: config.lookupEnv, values: make(map[string]*string)}
+	if env.lookup == nil {
+		env.lookup = os.LookupEnv
+	}
+	config.lookupEnv = env.getenv
+	for _, sectionName := range config.sectionNames() {
+		options := make(ConfigOption)
+		config.mergeInto(options, sectionName, make(map[string]bool))
+		for optionName, value := range options {
+			config.expand(sectionName, optionName, value, nil) // Errors are reported when it is used
+		}
+	}
+}
+
+// clone returns a deep copy of the configuration. The caller must hold
+// at least a read lock.
+func (config *Configuration) clone() *Configuration {
+	c := &Configuration{
+		ConfigMap:             make(map[string]ConfigOption, len(config.ConfigMap)),
+		Inheritance:           make(map[string][]string, len(config.Inheritance)),
+		SectionOrder:          append([]string(nil), config.SectionOrder...),
+		OptionOrder:           make(map[string][]string, len(config.OptionOrder)),
+		Sections:              config.Sections,
+		OnDefaultAddToSection: config.OnDefaultAddToSection,
+		IsLoaded:              config.IsLoaded,
+		IsCache:               config.IsCache,
+		ignoreCache:           config.ignoreCache,
+		ConfigFile:            config.ConfigFile,
+		cacheFile:             config.cacheFile,
+		sources:               append([]string(nil), config.sources...),
+		globs:                 append([]string(nil), config.globs...),
+		interpolation:         config.interpolation,
+		document:              config.document, // Not changed after parsing
+		origins:               make(map[string]map[string][]Origin, len(config.origins)),
+		lookupEnv:             config.lookupEnv,
+		strictEnv:             config.strictEnv,
+	}
+	for section, options := range config.ConfigMap {
+		copied := make(ConfigOption, len(options))
+		for option, value := range options {
+			copied[option] = value
+		}
+		c.ConfigMap[section] = copied
+	}
+	for section, parents := range config.Inheritance {
+		c.Inheritance[section] = append([]string(nil), parents...)
+	}
+	for section, order := range config.OptionOrder {
+		c.OptionOrder[section] = append([]string(nil), order...)
+	}
+	for section, options := range config.comments {
+		copied := make(map[string]string, len(options))
+		for option, comment := range options {
+			copied[option] = comment
+		}
+		if c.comments == nil {
+			c.comments = make(map[string]map[string]string, len(config.comments))
+		}
+		c.comments[section] = copied
+	}
+	for section, options := range config.origins {
+		copied := make(map[string][]Origin, len(options))
+		for option, history := range options {
+			copied[option] = append([]Origin(nil), history...)
+		}
+		c.origins[section] = copied
+	}
+	return c
+}
+
+// Configuration will return a new, writable copy of the snapshot. It uses
+// the environment variables as they were when the snapshot was made.
+func (snap *Snapshot) Configuration() *Configuration {
+	return snap.config.clone()
+}
+
+// IsSection will return true if a section name exists in the snapshot
+func (snap *Snapshot) IsSection(sectionName string) bool {
+	return snap.config.IsSection(sectionName)
+}
+
+// IsOption will return true if the option can be found in the section
+// or one of its parents
+func (snap *Snapshot) IsOption(sectionName, optionName string) bool {
+	return snap.config.IsOption(sectionName, optionName)
+}
+
+// GetSection will return a copy of the options of a section, including
+// those inherited from its parents
+func (snap *Snapshot) GetSection(sectionName string) (ConfigOption, bool) {
+	return snap.config.GetSection(sectionName)
+}
+
+// GetOwnSection will return a copy of the options set in a section itself
+func (snap *Snapshot) GetOwnSection(sectionName string) (ConfigOption, bool) {
+	return snap.config.GetOwnSection(sectionName)
+}
+
+// GetMergedSection will return the options of a section, including those
+// inherited from its parents
+func (snap *Snapshot) GetMergedSection(sectionName string) (ConfigOption, bool) {
+	return snap.config.GetMergedSection(sectionName)
+}
+
+// GetSectionNames will return the sections in the order they were defined
+func (snap *Snapshot) GetSectionNames() []string {
+	return snap.config.GetSectionNames()
+}
+
+// OptionNames will return the options set in a section, in the order
+// they were defined
+func (snap *Snapshot) OptionNames(sectionName string) []string {
+	return snap.config.OptionNames(sectionName)
+}
+
+// Parents will return the sections a section inherits from directly
+func (snap *Snapshot) Parents(sectionName string) []string {
+	return snap.config.Parents(sectionName)
+}
+
+// Ancestors will return every section a section inherits from, in the
+// order they are searched
+func (snap *Snapshot) Ancestors(sectionName string) []string {
+	return snap.config.Ancestors(sectionName)
+}
+
+// GetRawString will return an option without replacing any references
+func (snap *Snapshot) GetRawString(sectionName, optionName string) (string, error) {
+	return snap.config.GetRawString(sectionName, optionName)
+}
+
+// GetString will search a section for a specific option. If the option
+// or section doesn't exist, an error will be returned.
+func (snap *Snapshot) GetString(sectionName, optionName string) (string, error) {
+	return snap.config.GetString(sectionName, optionName)
+}
+
+// GetStringWithDefault will return an option, or the default value if it
+// can't be found. Unlike Configuration.GetStringWithDefault nothing is
+// recorded, as a snapshot can't be changed.
+func (snap *Snapshot) GetStringWithDefault(sectionName, optionName, defaultValue string) string {
+	value, err := snap.config.GetString(sectionName, optionName)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// GetInt will return an option converted to an int64
+func (snap *Snapshot) GetInt(sectionName, optionName string) (int64, error) {
+	return snap.config.GetInt(sectionName, optionName)
+}
+
+// GetIntWithDefault will return an int64 or the default value if nothing
+// is available
+func (snap *Snapshot) GetIntWithDefault(sectionName, optionName string, defaultValue int64) (int64, error) {
+	value := snap.GetStringWithDefault(sectionName, optionName, strconv.FormatInt(defaultValue, 10))
+	return parseInt(sectionName, optionName, value)
+}
+
+// GetBool will return an option converted to a bool
+func (snap *Snapshot) GetBool(sectionName, optionName string) (bool, error) {
+	return snap.config.GetBool(sectionName, optionName)
+}
+
+// GetBoolWithDefault will return a bool or the default value if nothing
+// is available
+func (snap *Snapshot) GetBoolWithDefault(sectionName, optionName string, defaultValue bool) (bool, error) {
+	return snap.config.GetBoolWithDefault(sectionName, optionName, defaultValue)
+}
+
+// Explain will return the history of an option, see Configuration.Explain
+func (snap *Snapshot) Explain(sectionName, optionName string) ([]Origin, error) {
+	return snap.config.Explain(sectionName, optionName)
+}
+
+// Sources will return the names of the files the snapshot was read from
+func (snap *Snapshot) Sources() []string {
+	return snap.config.Sources()
+}
+
+// WriteTo will write the snapshot out as an ini file
+func (snap *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	return snap.config.WriteTo(w)
+}
+
+// String will convert the snapshot into a printable format
+func (snap *Snapshot) String() string {
+	return snap.config.String()
+}
+
+// Holder holds the current snapshot of a configuration. Readers call
+// Snapshot once, at the start of a request, and use it throughout; a
+// reload calls Store to swap in the new version. Readers that already
+// have the old snapshot keep using it.
+type Holder struct {
+	current atomic.Pointer[Snapshot]
+
+	mu       sync.Mutex // Serializes Store, so handlers see changes in order
+	handlers []changeHandler
+}
+
+// NewHolder will return a holder with a snapshot of the configuration
+func NewHolder(config *Configuration) *Holder {
+	holder := &Holder{}
+	holder.Store(config)
+	return holder
+}
+
+// Snapshot will return the current snapshot
+func (holder *Holder) Snapshot() *Snapshot {
+	return holder.current.Load()
+}
+
+// Store will take a snapshot of the configuration and make it the
+// current one. Any OnChange handlers for options that changed are
+// called before it returns. The new snapshot is returned.
+func (holder *Holder) Store(config *Configuration) *Snapshot {
+	snap := config.Snapshot()
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	holder.notify(holder.current.Swap(snap), snap)
+	return snap
+}
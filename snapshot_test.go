@@ -0,0 +1,137 @@
+package gofig
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSnapshotIsACopy(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_concurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := config.Snapshot()
+
+	config.SetString("db", "host", "changed")
+	config.DeleteSection("testdb")
+	config.AddParent("db", "other")
+
+	if host, _ := snap.GetString("db", "host"); host != "localhost" {
+		t.Errorf("Snapshot saw a change made after it was taken: host is %q", host)
+	}
+	if !snap.IsSection("testdb") {
+		t.Errorf("Snapshot lost a section deleted after it was taken")
+	}
+	if url, _ := snap.GetString("testdb", "url"); url != "postgres://testhost:5432" {
+		t.Errorf("Snapshot url is %q", url)
+	}
+	if parents := snap.Parents("db"); len(parents) != 0 {
+		t.Errorf("Snapshot saw a new parent: %v", parents)
+	}
+
+	section, _ := snap.GetSection("db")
+	section["host"] = "mine"
+	if host, _ := snap.GetString("db", "host"); host != "localhost" {
+		t.Errorf("Changing the map from GetSection changed the snapshot")
+	}
+}
+
+func TestSnapshotEnvFixed(t *testing.T) {
+	config, err := NewConfigurationFromIniString("[app]\nhome = ${env:APP_HOME}\nuser = ${APP_USER:-nobody}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	env := map[string]string{"APP_HOME": "/one"}
+	config.SetEnvLookup(func(name string) (string, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		value, found := env[name]
+		return value, found
+	})
+	snap := config.Snapshot()
+
+	mu.Lock()
+	env["APP_HOME"], env["APP_USER"] = "/two", "admin"
+	mu.Unlock()
+	if home, _ := snap.GetString("app", "home"); home != "/one" {
+		t.Errorf("Snapshot saw an environment change: home is %q", home)
+	}
+	if user, _ := snap.GetString("app", "user"); user != "nobody" {
+		t.Errorf("Snapshot saw a variable set after it was taken: user is %q", user)
+	}
+	checkSection(t, config, "app", "home", "/two")
+}
+
+func TestSnapshotDefaultsNotRecorded(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_concurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetAddOnDefault(true)
+	snap := config.Snapshot()
+
+	if value := snap.GetStringWithDefault("db", "timeout", "30"); value != "30" {
+		t.Errorf("Default was %q rather than 30", value)
+	}
+	if i, err := snap.GetIntWithDefault("db", "retries", 3); err != nil || i != 3 {
+		t.Errorf("Int default was %d, %v", i, err)
+	}
+	if snap.IsOption("db", "timeout") || snap.IsOption("_default", "db") {
+		t.Errorf("A snapshot should not record defaults")
+	}
+}
+
+func TestHolderSwap(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_concurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder := NewHolder(config)
+	old := holder.Snapshot()
+
+	next, _ := NewConfigurationFromIniString("[db]\nhost = newhost\nport = 1\n")
+	if snap := holder.Store(next); snap != holder.Snapshot() {
+		t.Errorf("Store did not return the current snapshot")
+	}
+
+	if host, _ := old.GetString("db", "host"); host != "localhost" {
+		t.Errorf("Old snapshot changed after a swap: host is %q", host)
+	}
+	if host, _ := holder.Snapshot().GetString("db", "host"); host != "newhost" {
+		t.Errorf("New snapshot has host %q", host)
+	}
+}
+
+func TestHolderConcurrent(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_concurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder := NewHolder(config)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				config.SetString("db", "port", "1")
+				holder.Store(config)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				snap := holder.Snapshot()
+				first, _ := snap.GetString("db", "port")
+				second, _ := snap.GetString("db", "port")
+				if first != second {
+					t.Errorf("Snapshot changed while in use: %s then %s", first, second)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
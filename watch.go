@@ -0,0 +1,317 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"crypto/sha256"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is how often a Watcher looks at its files when
+// no interval is given
+const DefaultWatchInterval = 2 * time.Second
+
+// Loader reads a configuration. It is called once when a Watcher is made
+// and again each time the files change or a reload signal arrives.
+type Loader func() (*Configuration, error)
+
+// FileLoader will return a Loader that reads an ini-style file with the
+// options given, as NewConfigurationFromIniFileWithOptions does.
+func FileLoader(filename string, options ParseOptions) Loader {
+	return func() (*Configuration, error) {
+		return NewConfigurationFromIniFileWithOptions(filename, options)
+	}
+}
+
+// WatchOptions controls how a Watcher checks for and loads changes
+type WatchOptions struct {
+	// How often the files are checked. DefaultWatchInterval if zero.
+	// If it is less than zero the files aren't polled, and the
+	// configuration is only loaded again by Reload or a signal.
+	Interval time.Duration
+
+	// Files to watch as well as the Sources of each configuration loaded.
+	// A configuration read from a cache has no sources, so the ini file
+	// should be listed here.
+	Files []string
+
+	// Validate, if set, is called with each new configuration. If it
+	// returns an error the new configuration is not used.
+	Validate func(*Configuration) error
+
+	// OnError, if set, is called when a reload fails. The configuration
+	// in use is kept.
+	OnError func(error)
+
+	// Signals that cause a reload, see Watcher.ReloadOnSignal
+	Signals []os.Signal
+}
+
+// fileStamp is what a Watcher knows about a file, to see if it changed
+type fileStamp struct {
+	missing bool
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// Watcher keeps a configuration up to date with the files it was read
+// from. The files are polled and, when one of them changes or a new file
+// matches an include glob, the configuration is loaded again. The new
+// configuration only replaces the old one if it loads and validates
+// without errors.
+type Watcher struct {
+	holder  *Holder
+	loader  Loader
+	options WatchOptions
+
+	mu      sync.Mutex // Serializes reloads
+	stamps  map[string]fileStamp
+	matches map[string][sha256.Size]byte // glob => sum of the names it matches
+
+	signals  chan os.Signal
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewWatcher will read an ini-style file and check it, and any files it
+// includes, for changes every interval.
+func NewWatcher(filename string, interval time.Duration) (*Watcher, error) {
+	return NewWatcherWithOptions(FileLoader(filename, DefaultParseOptions()),
+		WatchOptions{Interval: interval, Files: []string{filename}})
+}
+
+// NewWatcherWithOptions will load a configuration with the loader and
+// watch its files for changes. An error is returned, and nothing is
+// watched, if the first load fails.
+func NewWatcherWithOptions(loader Loader, options WatchOptions) (*Watcher, error) {
+	if options.Interval == 0 {
+		options.Interval = DefaultWatchInterval
+	}
+	w := &Watcher{
+		loader:  loader,
+		options: options,
+		stamps:  make(map[string]fileStamp),
+		matches: make(map[string][sha256.Size]byte),
+		signals: make(chan os.Signal, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	w.changed() // Record the files as they are before the first load
+
+	config, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	w.holder = NewHolder(config)
+	w.track() // and any that are only known after it
+
+	if len(options.Signals) > 0 {
+		w.ReloadOnSignal(options.Signals...)
+	}
+	go w.run()
+	return w, nil
+}
+
+// NewSignalReloader will load a configuration with the loader and load it
+// again each time the process gets SIGHUP. The files are not polled. Failed
+// reloads are passed to onError, which may be nil, and the configuration in
+// use is kept. Use OnChange on the Watcher to see what a reload changed.
+func NewSignalReloader(loader Loader, onError func(error)) (*Watcher, error) {
+	w, err := NewWatcherWithOptions(loader, WatchOptions{Interval: -1, OnError: onError})
+	if err != nil {
+		return nil, err
+	}
+	w.ReloadOnSignal()
+	return w, nil
+}
+
+// ReloadOnSignal will load the configuration again each time one of the
+// signals arrives, whether or not the files have changed. With no signals
+// the usual reload signal is used: SIGHUP, on systems that have it. The
+// result is handled as for a change to the files: it is stored if there
+// is no error, and otherwise passed to WatchOptions.OnError.
+func (w *Watcher) ReloadOnSignal(signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = reloadSignals
+	}
+	if len(signals) > 0 {
+		signal.Notify(w.signals, signals...)
+	}
+}
+
+// Holder will return the holder of the current configuration
+func (w *Watcher) Holder() *Holder {
+	return w.holder
+}
+
+// Snapshot will return the current configuration
+func (w *Watcher) Snapshot() *Snapshot {
+	return w.holder.Snapshot()
+}
+
+// Reload will load the configuration now, whether or not the files have
+// changed. The configuration in use is only replaced if there is no error.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.changed()
+	return w.reload()
+}
+
+// Stop will stop watching the files and any signals. The last
+// configuration loaded can still be used.
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		signal.Stop(w.signals)
+		close(w.stop)
+	})
+	<-w.done
+}
+
+// run polls the files, and waits for signals, until the watcher is stopped
+func (w *Watcher) run() {
+	defer close(w.done)
+	var tick <-chan time.Time // nil, and never ready, if not polling
+	if w.options.Interval > 0 {
+		ticker := time.NewTicker(w.options.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-tick:
+			w.poll(false)
+		case <-w.signals:
+			w.poll(true)
+		}
+	}
+}
+
+// poll reloads the configuration if any of the files have changed, or
+// always if forced. Errors are passed to WatchOptions.OnError.
+func (w *Watcher) poll(force bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.changed() && !force {
+		return
+	}
+	if err := w.reload(); err != nil && w.options.OnError != nil {
+		w.options.OnError(err)
+	}
+}
+
+// load runs the loader and the validation
+func (w *Watcher) load() (*Configuration, error) {
+	config, err := w.loader()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("Loader returned no configuration")
+	}
+	if w.options.Validate != nil {
+		if err := w.options.Validate(config); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// reload loads the configuration and swaps it in if there was no error
+func (w *Watcher) reload() error {
+	config, err := w.load()
+	if err != nil {
+		return err
+	}
+	w.holder.Store(config)
+	w.track() // Start watching any new included files
+	return nil
+}
+
+// watched returns every file being watched, and the include globs whose
+// matches are watched so a new file in conf.d/ is seen.
+func (w *Watcher) watched() (files, globs []string) {
+	files = append([]string(nil), w.options.Files...)
+	if w.holder != nil {
+		snap := w.holder.Snapshot()
+		files = append(files, snap.Sources()...)
+		globs = snap.config.globs // The snapshot never changes
+	}
+	return files, globs
+}
+
+// changed updates the stamps of the files and returns true if any of
+// them have changed. The hash is only worked out when the time or size
+// is different, so touching a file doesn't cause a reload. It is called
+// before a load, so a change made while loading is seen by the next poll.
+func (w *Watcher) changed() bool {
+	changed := false
+	files, globs := w.watched()
+	for _, file := range files {
+		stamp := stampFile(file, w.stamps[file])
+		if old, found := w.stamps[file]; found && stamp != old {
+			changed = changed || stamp.missing != old.missing || stamp.sum != old.sum
+		}
+		w.stamps[file] = stamp
+	}
+	for _, glob := range globs {
+		sum := sumGlob(glob)
+		if old, found := w.matches[glob]; found && sum != old {
+			changed = true
+		}
+		w.matches[glob] = sum
+	}
+	return changed
+}
+
+// track adds stamps for the files and globs found by the last load. The
+// stamps of files already watched are kept as they were before the load.
+func (w *Watcher) track() {
+	files, globs := w.watched()
+	for _, file := range files {
+		if _, found := w.stamps[file]; !found {
+			w.stamps[file] = stampFile(file, fileStamp{})
+		}
+	}
+	for _, glob := range globs {
+		if _, found := w.matches[glob]; !found {
+			w.matches[glob] = sumGlob(glob)
+		}
+	}
+}
+
+// sumGlob returns the sum of the names that a glob matches
+func sumGlob(glob string) [sha256.Size]byte {
+	matches, _ := filepath.Glob(glob)
+	return sha256.Sum256([]byte(strings.Join(matches, "\n")))
+}
+
+// stampFile returns the stamp of a file. The sum is only read again if
+// the time or size differ from the old stamp.
+func stampFile(file string, old fileStamp) fileStamp {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileStamp{missing: true}
+	}
+	stamp := fileStamp{modTime: info.ModTime(), size: info.Size(), sum: old.sum}
+	if old.missing || !stamp.modTime.Equal(old.modTime) || stamp.size != old.size {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fileStamp{missing: true}
+		}
+		stamp.sum = sha256.Sum256(data)
+	}
+	return stamp
+}
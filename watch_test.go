@@ -0,0 +1,210 @@
+package gofig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls until the condition is true, failing the test after a while
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatcherReloads(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.ini":  "[db]\nhost = one\ninclude extra.ini\n",
+		"extra.ini": "port = 1\n",
+	})
+	main := filepath.Join(dir, "main.ini")
+
+	w, err := NewWatcher(main, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	first := w.Snapshot()
+
+	if err := os.WriteFile(main, []byte("[db]\nhost = second\ninclude extra.ini\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "main file reload", func() bool {
+		host, _ := w.Snapshot().GetString("db", "host")
+		return host == "second"
+	})
+	if host, _ := first.GetString("db", "host"); host != "one" {
+		t.Errorf("The first snapshot changed: host is %q", host)
+	}
+
+	// Changes to included files are seen too
+	if err := os.WriteFile(filepath.Join(dir, "extra.ini"), []byte("port = 22\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "included file reload", func() bool {
+		port, _ := w.Snapshot().GetString("db", "port")
+		return port == "22"
+	})
+}
+
+func TestWatcherKeepsConfigOnError(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.ini": "[db]\nport = 1\n"})
+	main := filepath.Join(dir, "main.ini")
+
+	var mu sync.Mutex
+	var failures []error
+	errBadPort := errors.New("port must not be 0")
+	w, err := NewWatcherWithOptions(FileLoader(main, DefaultParseOptions()), WatchOptions{
+		Interval: 10 * time.Millisecond,
+		Files:    []string{main},
+		Validate: func(config *Configuration) error {
+			if port, _ := config.GetString("db", "port"); port == "0" {
+				return errBadPort
+			}
+			return nil
+		},
+		OnError: func(err error) {
+			mu.Lock()
+			failures = append(failures, err)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	failed := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(failures)
+	}
+
+	// A file that doesn't parse
+	if err := os.WriteFile(main, []byte("[db\nport = 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "parse error", func() bool { return failed() == 1 })
+	mu.Lock()
+	var perr *ParseError
+	if !errors.As(failures[0], &perr) {
+		t.Errorf("Expected a *ParseError, got %v", failures[0])
+	}
+	mu.Unlock()
+
+	// A file that doesn't validate
+	if err := os.WriteFile(main, []byte("[db]\nport = 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "validation error", func() bool { return failed() == 2 })
+	mu.Lock()
+	if !errors.Is(failures[1], errBadPort) {
+		t.Errorf("Expected the validation error, got %v", failures[1])
+	}
+	mu.Unlock()
+
+	if port, _ := w.Snapshot().GetString("db", "port"); port != "1" {
+		t.Errorf("A bad reload replaced the configuration: port is %q", port)
+	}
+	if err := w.Reload(); !errors.Is(err, errBadPort) {
+		t.Errorf("Reload should return the validation error, got %v", err)
+	}
+}
+
+func TestWatcherIgnoresTouch(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.ini": "[db]\nport = 1\n"})
+	main := filepath.Join(dir, "main.ini")
+
+	loads := 0
+	var mu sync.Mutex
+	loader := func() (*Configuration, error) {
+		mu.Lock()
+		loads++
+		mu.Unlock()
+		return NewConfigurationFromIniFile(main)
+	}
+	w, err := NewWatcherWithOptions(loader, WatchOptions{Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(main, later, later); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	w.Stop()
+	w.Stop() // Stopping twice is harmless
+
+	mu.Lock()
+	defer mu.Unlock()
+	if loads != 1 {
+		t.Errorf("Touching the file caused %d loads", loads-1)
+	}
+}
+
+func TestWatcherFirstLoadFails(t *testing.T) {
+	if _, err := NewWatcher(filepath.Join(t.TempDir(), "missing.ini"), time.Second); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file error, got %v", err)
+	}
+}
+
+func TestWatcherEditDuringLoad(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.ini": "[db]\nhost = one\n"})
+	main := filepath.Join(dir, "main.ini")
+
+	// The file is changed after the second load has read it
+	var mu sync.Mutex
+	loads := 0
+	loader := func() (*Configuration, error) {
+		config, err := NewConfigurationFromIniFile(main)
+		mu.Lock()
+		defer mu.Unlock()
+		if loads++; loads == 2 {
+			if err := os.WriteFile(main, []byte("[db]\nhost = three\n"), 0644); err != nil {
+				t.Error(err)
+			}
+		}
+		return config, err
+	}
+	w, err := NewWatcherWithOptions(loader, WatchOptions{Interval: 10 * time.Millisecond, Files: []string{main}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	if err := os.WriteFile(main, []byte("[db]\nhost = two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the edit made while loading", func() bool {
+		host, _ := w.Snapshot().GetString("db", "host")
+		return host == "three"
+	})
+}
+
+func TestWatcherNewGlobMatch(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.ini":     "include conf.d/*.ini\n",
+		"conf.d/a.ini": "[a]\nvalue = a\n",
+	})
+	w, err := NewWatcher(filepath.Join(dir, "main.ini"), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	if err := os.WriteFile(filepath.Join(dir, "conf.d", "b.ini"), []byte("[b]\nvalue = b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a new file matching the include", func() bool {
+		return w.Snapshot().IsSection("b")
+	})
+}
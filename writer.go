@@ -0,0 +1,318 @@
+// Copyright 2014 Charles Gentry All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gofig
+
+import (
+	"io"
+	"strings"
+)
+
+// WriteTo will write the configuration out as an ini file that can be read
+// back in. If the configuration was read with ParseOptions.KeepDocument,
+// the original file is written with only the changed options altered,
+// removed or added. Otherwise the sections and options are written in the
+// order they were defined. The '_default' bookkeeping section is skipped.
+func (config *Configuration) WriteTo(w io.Writer) (int64, error) {
+	config.mu.RLock()
+	var out strings.Builder
+	if config.document != nil {
+		config.writeDocument(&out)
+	} else {
+		config.writeIni(&out)
+	}
+	config.mu.RUnlock()
+	n, err := io.WriteString(w, out.String())
+	return int64(n), err
+}
+
+// writeOption writes a single 'key = value' line
+func writeOption(out *strings.Builder, option, value string) {
+	out.WriteString(option + " = " + quoteValue(value) + "\n")
+}
+
+// setComment sets the comment written before an option, or before the
+// section line when the option is "". An empty comment is ignored.
+func (config *Configuration) setComment(sectionName, optionName, comment string) {
+	if comment == "" {
+		return
+	}
+	sectionName = conformSectionName(sectionName)
+	if config.comments == nil {
+		config.comments = make(map[string]map[string]string)
+	}
+	if config.comments[sectionName] == nil {
+		config.comments[sectionName] = make(map[string]string)
+	}
+	config.comments[sectionName][optionName] = comment
+}
+
+// writeComment writes the comment for an option or section, if it has one
+func (config *Configuration) writeComment(out *strings.Builder, sectionName, optionName string) {
+	comment, found := config.comments[sectionName][optionName]
+	if !found {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		out.WriteString(strings.TrimRight("; "+line, " ") + "\n")
+	}
+}
+
+// writeHeader writes a section line, with any parent sections
+func (config *Configuration) writeHeader(out *strings.Builder, sectionName string) {
+	names := append([]string{sectionName}, config.Inheritance[sectionName]...)
+	out.WriteString("[" + strings.Join(names, " : ") + "]\n")
+}
+
+// writeIni writes the whole configuration in a plain format. Options in
+// the 'default' section are written first, without a section line.
+func (config *Configuration) writeIni(out *strings.Builder) {
+	for _, option := range config.optionNames("default") {
+		config.writeComment(out, "default", option)
+		writeOption(out, option, config.ConfigMap["default"][option])
+	}
+	for _, sectionName := range config.sectionNames() {
+		if sectionName == "_default" || sectionName == "default" {
+			continue
+		}
+		if out.Len() > 0 {
+			out.WriteString("\n")
+		}
+		config.writeComment(out, sectionName, "")
+		config.writeHeader(out, sectionName)
+		for _, option := range config.optionNames(sectionName) {
+			config.writeComment(out, sectionName, option)
+			writeOption(out, option, config.ConfigMap[sectionName][option])
+		}
+	}
+}
+
+// docKind is what a line in a kept document holds
+type docKind int
+
+const (
+	docText    docKind = iota // blank lines, comments, includes and bad lines
+	docSection                // a section line
+	docOption                 // a key = value line
+)
+
+// docLine is one logical line of a kept document
+type docLine struct {
+	kind    docKind
+	raw     []string // the physical lines, as read
+	section string   // the section the line is in
+	option  string   // name of the option for docOption lines
+	value   string   // the value as read, to see if it has changed
+	parents []string // parents named on a docSection line
+}
+
+// document holds the lines of the main file, as read, so it can be
+// written back out with as few changes as possible.
+type document struct {
+	file     string
+	options  ParseOptions    // how the file was parsed, for inline comments
+	lines    []*docLine      // every line of the main file
+	included map[string]bool // sections found in included files
+}
+
+// add records a line that has just been parsed. Lines that failed to
+// parse (in lenient mode) are kept as text.
+func (doc *document) add(p *parser, l *logicalLine, failed bool) {
+	line := &docLine{kind: docText, raw: l.raw, section: p.section}
+	switch {
+	case failed || p.ignore || l.text == "" || isComment(l.text):
+	case l.text[0] == '[':
+		line.kind = docSection
+		names := strings.Split(strings.Trim(p.stripComment(l.text), "[]"), ":")
+		for _, name := range names[1:] {
+			line.parents = append(line.parents, conformSectionName(name))
+		}
+		doc.attach(p.section)
+	default:
+		if _, _, ok := splitDirective(l.text); ok {
+			break
+		}
+		line.kind = docOption
+		line.option = conformOption(strings.SplitN(l.text, "=", 2)[0])
+		line.value = p.config.ConfigMap[p.section][line.option]
+	}
+	doc.lines = append(doc.lines, line)
+}
+
+// attach moves the blank lines and comments just before a section line
+// into that section, so they go with it if it is deleted. At the top of
+// the file, only the lines from the last blank line on are moved.
+func (doc *document) attach(sectionName string) {
+	i := len(doc.lines)
+	for i > 0 && doc.lines[i-1].isBlankOrComment() {
+		i--
+	}
+	if i == 0 {
+		for j, line := range doc.lines {
+			if strings.TrimSpace(line.raw[0]) == "" {
+				i = j
+			}
+		}
+	}
+	for _, line := range doc.lines[i:] {
+		line.section = sectionName
+	}
+}
+
+// isBlankOrComment returns true for a text line that is blank or a comment
+func (line *docLine) isBlankOrComment() bool {
+	text := strings.TrimSpace(line.raw[0])
+	return line.kind == docText && len(line.raw) == 1 && (text == "" || isComment(text))
+}
+
+// rewrite returns an option line with a new value. The spacing before the
+// value and any inline comment on the line are kept.
+func (doc *document) rewrite(line *docLine, value string) string {
+	raw := line.raw[0]
+	eq := strings.Index(raw, "=")
+	if eq < 0 {
+		return line.option + " = " + quoteValue(value)
+	}
+	text := strings.TrimLeft(raw[eq+1:], " \t")
+	prefix := raw[:len(raw)-len(text)]
+
+	comment := ""
+	if len(line.raw) == 1 {
+		p := &parser{options: doc.options}
+		if stripped := p.stripComment(raw[eq+1:]); strings.HasPrefix(text, stripped) {
+			comment = text[len(stripped):]
+		}
+		if strings.TrimSpace(comment) == "" {
+			comment = ""
+		} else if comment[0] != ' ' && comment[0] != '\t' {
+			comment = " " + comment // The value was empty
+		}
+	}
+	return prefix + quoteValue(value) + comment
+}
+
+// fromInclude returns true if the option was last set by an included file
+func (config *Configuration) fromInclude(sectionName, optionName string) bool {
+	history := config.origins[sectionName][optionName]
+	if len(history) == 0 {
+		return false
+	}
+	origin := history[len(history)-1]
+	return origin.Line > 0 && origin.Source != config.document.file
+}
+
+// writeNewOptions writes the options of a section that are not in the
+// document and didn't come from an included file.
+func (config *Configuration) writeNewOptions(out *strings.Builder, sectionName string, inDocument map[string]bool) int {
+	count := 0
+	for _, option := range config.optionNames(sectionName) {
+		if !inDocument[sectionName+"\x00"+option] && !config.fromInclude(sectionName, option) {
+			writeOption(out, option, config.ConfigMap[sectionName][option])
+			count++
+		}
+	}
+	return count
+}
+
+// writeDocument writes the kept document, changing only what is needed.
+func (config *Configuration) writeDocument(out *strings.Builder) {
+	doc := config.document
+	lastOption := make(map[string]int)  // section/option => last line setting it
+	lastLine := make(map[string]int)    // section => last section or option line
+	firstHeader := make(map[string]int) // section => first section line
+	declared := make(map[string][]string)
+	inDocument := make(map[string]bool)
+
+	for i, line := range doc.lines {
+		switch line.kind {
+		case docSection:
+			lastLine[line.section] = i
+			if _, found := firstHeader[line.section]; !found {
+				firstHeader[line.section] = i
+			}
+			declared[line.section] = append(declared[line.section], line.parents...)
+		case docOption:
+			lastLine[line.section] = i
+			lastOption[line.section+"\x00"+line.option] = i
+			inDocument[line.section+"\x00"+line.option] = true
+		}
+	}
+
+	if _, found := lastLine["default"]; !found {
+		config.writeNewOptions(out, "default", inDocument)
+	}
+	for i, line := range doc.lines {
+		if _, hasHeader := firstHeader[line.section]; hasHeader && !config.isSection(line.section) {
+			continue // The section was deleted
+		}
+
+		switch line.kind {
+		case docSection:
+			if i == firstHeader[line.section] && !sameNames(declared[line.section], config.Inheritance[line.section]) {
+				config.writeHeader(out, line.section)
+			} else {
+				writeLines(out, line.raw)
+			}
+		case docOption:
+			value, found := config.ConfigMap[line.section][line.option]
+			switch {
+			case !found:
+			case i != lastOption[line.section+"\x00"+line.option] || value == line.value:
+				writeLines(out, line.raw)
+			default:
+				out.WriteString(doc.rewrite(line, value) + "\n")
+			}
+		default:
+			writeLines(out, line.raw)
+		}
+
+		if last, found := lastLine[line.section]; found && last == i {
+			config.writeNewOptions(out, line.section, inDocument)
+		}
+	}
+
+	// Finally, any new sections
+	for _, sectionName := range config.sectionNames() {
+		if _, found := firstHeader[sectionName]; found || sectionName == "_default" || sectionName == "default" {
+			continue
+		}
+		var options strings.Builder
+		if config.writeNewOptions(&options, sectionName, inDocument) == 0 && doc.included[sectionName] {
+			continue // Only in an included file
+		}
+		if out.Len() > 0 {
+			out.WriteString("\n")
+		}
+		config.writeHeader(out, sectionName)
+		out.WriteString(options.String())
+	}
+}
+
+// writeLines writes the physical lines as they were read
+func writeLines(out *strings.Builder, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line + "\n")
+	}
+}
+
+// sameNames returns true if both lists hold the same names, ignoring order
+// and duplicates.
+func sameNames(a, b []string) bool {
+	names, others := make(map[string]bool), make(map[string]bool)
+	for _, name := range a {
+		names[name] = true
+	}
+	for _, name := range b {
+		others[name] = true
+	}
+	if len(names) != len(others) {
+		return false
+	}
+	for name := range names {
+		if !others[name] {
+			return false
+		}
+	}
+	return true
+}
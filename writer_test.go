@@ -0,0 +1,159 @@
+package gofig
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testdata_document = `; Main settings
+name = app   ; the name
+
+[db]
+# Where the database lives
+host   = localhost   ; primary
+port   = 5432
+user=admin
+
+[old]
+gone = 1
+
+[child : db]
+port = 5433
+`
+
+func TestWriteTo(t *testing.T) {
+	config, err := NewConfigurationFromIniString(testdata_document + "[quoted]\nvalue = \"  two\\nlines ; here\"\n")
+	if err != nil {
+		t.Fatalf("Error: could not ini from string: %s", err)
+	}
+	var out strings.Builder
+	if _, err = config.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo failed: %s", err)
+	}
+	expect := `name = app
+
+[db]
+host = localhost
+port = 5432
+user = admin
+
+[old]
+gone = 1
+
+[child : db]
+port = 5433
+
+[quoted]
+value = "  two\nlines ; here"
+`
+	if out.String() != expect {
+		t.Errorf("WriteTo wrote:\n%s\nbut should have written:\n%s", out.String(), expect)
+	}
+
+	again, err := NewConfigurationFromIniString(out.String())
+	if err != nil {
+		t.Fatalf("Could not read back what was written: %s", err)
+	}
+	if !reflect.DeepEqual(config.ConfigMap, again.ConfigMap) || !reflect.DeepEqual(config.Inheritance, again.Inheritance) {
+		t.Errorf("Values changed when written and read back:\n%s\n%s", config, again)
+	}
+}
+
+func TestWriteTo_Document(t *testing.T) {
+	options := DefaultParseOptions()
+	options.KeepDocument = true
+	config, err := NewConfigurationFromIniStringWithOptions(testdata_document, options)
+	if err != nil {
+		t.Fatalf("Error: could not ini from string: %s", err)
+	}
+
+	var out strings.Builder
+	config.WriteTo(&out)
+	if out.String() != testdata_document {
+		t.Errorf("Unchanged document was not written as read:\n%s", out.String())
+	}
+
+	config.SetString("db", "host", "db1.example.com")
+	config.DeleteOption("db", "user")
+	config.SetString("db", "timeout", "30")
+	config.DeleteSection("old")
+	config.SetString("new", "key", "value")
+	config.SetString("default", "version", "2")
+	config.AddParent("child", "base")
+
+	expect := `; Main settings
+name = app   ; the name
+version = 2
+
+[db]
+# Where the database lives
+host   = db1.example.com   ; primary
+port   = 5432
+timeout = 30
+
+[child : db : base]
+port = 5433
+
+[new]
+key = value
+`
+	out.Reset()
+	config.WriteTo(&out)
+	if out.String() != expect {
+		t.Errorf("Document was written as:\n%s\nbut should be:\n%s", out.String(), expect)
+	}
+
+	// The comments before a section line go with that section
+	config, err = NewConfigurationFromIniStringWithOptions("; top\n\n; about a\n[a]\nx = 1\n\n; about b\n[b]\ny = 2\n", options)
+	if err != nil {
+		t.Fatalf("Error: could not ini from string: %s", err)
+	}
+	config.DeleteSection("a")
+	out.Reset()
+	config.WriteTo(&out)
+	if out.String() != "; top\n\n; about b\n[b]\ny = 2\n" {
+		t.Errorf("Deleting a section wrote:\n%s", out.String())
+	}
+
+	// A comment after an empty value is kept when it is set
+	config, err = NewConfigurationFromIniStringWithOptions("key = ; not set yet\n", options)
+	if err != nil {
+		t.Fatalf("Error: could not ini from string: %s", err)
+	}
+	config.SetString("default", "key", "v")
+	out.Reset()
+	config.WriteTo(&out)
+	if out.String() != "key = v ; not set yet\n" {
+		t.Errorf("Empty value was written as %q", out.String())
+	}
+}
+
+func TestWriteTo_DocumentEager(t *testing.T) {
+	options := DefaultParseOptions()
+	options.KeepDocument = true
+	options.Interpolation = InterpolateEager
+	if _, err := NewConfigurationFromIniStringWithOptions("base = /x\npath = ${base}/y\n", options); !errors.Is(err, ErrKeepDocumentEager) {
+		t.Errorf("Expected ErrKeepDocumentEager, got %v", err)
+	}
+}
+
+func TestWriteTo_DocumentWithInclude(t *testing.T) {
+	main := "[db]\nhost = main\ninclude site.ini\n"
+	dir := writeFiles(t, map[string]string{
+		"main.ini": main,
+		"site.ini": "user = site\n[site]\nname = one\n",
+	})
+	options := DefaultParseOptions()
+	options.KeepDocument = true
+	config, err := NewConfigurationFromIniFileWithOptions(dir+"/main.ini", options)
+	if err != nil {
+		t.Fatalf("Error: could not read ini: %s", err)
+	}
+	var out strings.Builder
+	config.WriteTo(&out)
+	if out.String() != main {
+		t.Errorf("Included values should not be written:\n%s", out.String())
+	}
+}